@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoints let a long crawl be resumed after it dies.  While CheckpointDir is
+// set, Crawl periodically pauses its workers and saves the frontier, the visited
+// URLs, the index and the page titles to checkpoint.gob in that directory, along
+// with the scope, trap and limit counts, and saves a last one when the crawl
+// ends, so a crawl stopped by a limit can be carried on too.  The resume command
+// loads a checkpoint and carries on crawling from its frontier.
+
+var CheckpointDir = ""
+var CheckpointInterval = time.Minute
+
+const checkpointFile = "checkpoint.gob"
+
+// A crawlRequest as saved in a checkpoint
+type checkpointRequest struct {
+	URL      string
+	Depth    int
+	Priority float64
+}
+
+type checkpoint struct {
+	RootURL    string
+	MaxDepth   int
+	Saved      time.Time
+	Frontier   []checkpointRequest
+	Visited    map[string]int
+	Entries    map[string][]IndexEntry
+	Pages      map[string]PageInfo
+	PageCount  int
+	Terms      int
+	Errors     []crawlError
+	NotIndexed int
+	Truncated  int
+	Duplicates int
+	Scope      scopeCounts
+	Traps      trapCounts
+	Limits     limitCounts
+}
+
+func newCheckpoint(rooturl string, maxdepth int, requests []crawlRequest, summary crawlSummary, visited *VisitedMap, index *Index, titles *URLtitles) checkpoint {
+	cp := checkpoint{
+		RootURL:    rooturl,
+		MaxDepth:   maxdepth,
+		Saved:      time.Now(),
+		Visited:    make(map[string]int),
+		Entries:    make(map[string][]IndexEntry),
+		Pages:      make(map[string]PageInfo),
+		PageCount:  summary.uniquePages,
+		Terms:      summary.uniqueTerms,
+		Errors:     summary.errors,
+		NotIndexed: summary.notIndexed,
+		Truncated:  summary.truncated,
+		Duplicates: summary.duplicates,
+	}
+	for _, r := range requests {
+		cp.Frontier = append(cp.Frontier, checkpointRequest{r.url, r.depth, r.priority})
+	}
+
+	visited.mux.Lock()
+	for k, v := range visited.v {
+		cp.Visited[k] = v
+	}
+	visited.mux.Unlock()
+
+	index.mux.Lock()
+	for k, v := range index.entries {
+		cp.Entries[k] = append([]IndexEntry(nil), v...)
+	}
+	index.mux.Unlock()
+
+	titles.mux.Lock()
+	for k, v := range titles.titles {
+		cp.Pages[k] = v
+	}
+	titles.mux.Unlock()
+	return cp
+}
+
+// Write the checkpoint to dir, replacing any earlier one only once it is complete
+func (cp checkpoint) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, checkpointFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(cp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, checkpointFile))
+}
+
+func loadCheckpoint(dir string) (checkpoint, error) {
+	var cp checkpoint
+	f, err := os.Open(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return cp, err
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&cp)
+	return cp, err
+}
+
+// The crawl summary counts saved in the checkpoint, which the resumed crawl adds to
+func (cp checkpoint) summary() crawlSummary {
+	return crawlSummary{uniquePages: cp.PageCount, uniqueTerms: cp.Terms, errors: cp.Errors, notIndexed: cp.NotIndexed, truncated: cp.Truncated, duplicates: cp.Duplicates}
+}
+
+// Replace the visited URLs, index and titles with those saved in the checkpoint
+func (cp checkpoint) Restore(visited *VisitedMap, index *Index, titles *URLtitles) {
+	visited.Reset()
+	index.Reset()
+	titles.Reset()
+
+	visited.mux.Lock()
+	for k, v := range cp.Visited {
+		visited.v[k] = v
+	}
+	visited.mux.Unlock()
+
+	index.mux.Lock()
+	for k, v := range cp.Entries {
+		index.entries[k] = v
+	}
+	index.mux.Unlock()
+
+	titles.mux.Lock()
+	for k, v := range cp.Pages {
+		titles.setPage(k, v)
+	}
+	titles.mux.Unlock()
+}
+
+// The resume command: continue the crawl saved in the checkpoint directory
+func Resume(dir string, visited *VisitedMap, index *Index, titles *URLtitles) {
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		fmt.Printf("Unable to load checkpoint from %v: %v\n", dir, err)
+		return
+	}
+	cp.Restore(visited, index, titles)
+	var requests []crawlRequest
+	for _, r := range cp.Frontier {
+		requests = append(requests, crawlRequest{r.URL, r.Depth, r.Priority})
+	}
+	progressf("Resuming crawl of %v saved %v with %v URLs waiting\n", cp.RootURL, cp.Saved.Format(time.RFC1123), len(requests))
+	results := crawlFrom(cp.RootURL, cp.MaxDepth, Concurrency, requests, &cp, dir, visited, index, titles)
+	lastCrawlErrors = results.errors
+	renderCrawlSummary(cp.RootURL, results)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A crawl stopped by a limit is saved, and carries on against the same limit
+// when resumed
+func TestResumeAfterLimit(t *testing.T) {
+	useFixtures(t)
+	maxPages, concurrency := MaxPages, Concurrency
+	defer func() { MaxPages, Concurrency = maxPages, concurrency }()
+	dir := t.TempDir()
+
+	MaxPages = 2
+	visited, index, titles := newTestIndex()
+	results := crawlFrom(fixtureSite+"/", 2, 1, []crawlRequest{{fixtureSite + "/", 0, 0}}, nil, dir, visited, index, titles)
+	if results.stoppedBy != "maxpages" || results.uniquePages != 2 {
+		t.Fatalf("crawled %v pages stopped by %q, want 2 and maxpages", results.uniquePages, results.stoppedBy)
+	}
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("no final checkpoint: %v", err)
+	}
+	if cp.PageCount != 2 || cp.Limits.Pages != 2 || len(cp.Frontier) == 0 {
+		t.Fatalf("checkpoint has %v pages, %v counted against maxpages and %v waiting", cp.PageCount, cp.Limits.Pages, len(cp.Frontier))
+	}
+
+	// the pages already crawled count against maxpages
+	MaxPages, Concurrency = 3, 1
+	Resume(dir, visited, index, titles)
+	if cp, _ = loadCheckpoint(dir); cp.PageCount != 3 || cp.Limits.Pages != 3 {
+		t.Fatalf("resumed crawl stopped at %v pages, %v counted against maxpages, want 3", cp.PageCount, cp.Limits.Pages)
+	}
+
+	MaxPages = 0
+	Resume(dir, visited, index, titles)
+	if cp, _ = loadCheckpoint(dir); cp.PageCount != 6 || len(cp.Frontier) != 0 {
+		t.Errorf("resumed crawl ended with %v pages and %v waiting, want 6 and none", cp.PageCount, len(cp.Frontier))
+	}
+	if got := indexedURLs(index, "ostriches"); len(got) != 1 {
+		t.Errorf("ostriches found on %v after resuming", got)
+	}
+}
+
+// A page cut short by maxduration is saved to be fetched when the crawl resumes
+func TestResumeAfterMaxDuration(t *testing.T) {
+	maxDuration, concurrency := MaxDuration, Concurrency
+	defer func() { MaxDuration, Concurrency = maxDuration, concurrency }()
+	var slow atomic.Bool
+	slow.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/slow.html">slow</a>`)
+			return
+		}
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		fmt.Fprint(w, "<html><body><p>sloths</p></body></html>")
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	child := server.URL + "/slow.html"
+
+	MaxDuration = 300 * time.Millisecond
+	visited, index, titles := newTestIndex()
+	results := crawlFrom(server.URL+"/", 1, 2, []crawlRequest{{server.URL + "/", 0, 0}}, nil, dir, visited, index, titles)
+	if results.stoppedBy != "maxduration" || results.uniquePages != 1 {
+		t.Fatalf("crawled %v pages stopped by %q, want 1 and maxduration", results.uniquePages, results.stoppedBy)
+	}
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("no final checkpoint: %v", err)
+	}
+	if _, ok := cp.Visited[visitedKey(child)]; ok || len(cp.Frontier) != 1 || cp.Frontier[0].URL != child {
+		t.Fatalf("checkpoint has %v waiting, want %v and not visited", cp.Frontier, child)
+	}
+
+	MaxDuration, Concurrency = 0, 1
+	slow.Store(false)
+	Resume(dir, visited, index, titles)
+	if cp, _ = loadCheckpoint(dir); cp.PageCount != 2 || len(cp.Frontier) != 0 {
+		t.Errorf("resumed crawl ended with %v pages and %v waiting, want 2 and none", cp.PageCount, len(cp.Frontier))
+	}
+	if got := indexedURLs(index, "sloths"); len(got) != 1 {
+		t.Errorf("sloths found on %v after resuming", got)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Configuration settings.  Each setting can be given a value by its default, the
+// config file, a command line flag or the set command, in that order of precedence,
+// and remembers where its current value came from.
+
+type setting struct {
+	name        string // used by the set command, the config file and command line flags
+	label       string // shown by the config command
+	description string
+	get         func() interface{}
+	set         func(string) error
+	source      string
+	separator   string // between the items of a list setting
+}
+
+// The current value in the form the set command takes
+func (s *setting) value() string {
+	if list, ok := s.get().([]string); ok {
+		return strings.Join(list, s.separator)
+	}
+	return fmt.Sprint(s.get())
+}
+
+func (s *setting) isBool() bool {
+	_, ok := s.get().(bool)
+	return ok
+}
+
+func boolSetting(name, label, description string, v *bool) *setting {
+	return &setting{name, label, description,
+		func() interface{} { return *v },
+		func(arg string) error {
+			b, err := strconv.ParseBool(arg)
+			if err != nil {
+				return fmt.Errorf("%v must be true or false", name)
+			}
+			*v = b
+			return nil
+		}, "default", ""}
+}
+
+// An integer setting that must be at least min
+func intSetting(name, label, description string, v *int, min int) *setting {
+	return &setting{name, label, description,
+		func() interface{} { return *v },
+		func(arg string) error {
+			i, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("%v not integer: %v", arg, err)
+			}
+			if i < min {
+				return fmt.Errorf("%v must be %v or more", name, min)
+			}
+			*v = i
+			return nil
+		}, "default", ""}
+}
+
+// A duration setting such as 90s or 10m, where 0 means no limit
+func durationSetting(name, label, description string, v *time.Duration) *setting {
+	return &setting{name, label, description,
+		func() interface{} { return v.String() },
+		func(arg string) error {
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return fmt.Errorf("%v not a duration: %v", arg, err)
+			}
+			if d < 0 {
+				return fmt.Errorf("%v must not be negative", name)
+			}
+			*v = d
+			return nil
+		}, "default", ""}
+}
+
+// A comma separated list setting
+func listSetting(name, label, description string, v *[]string) *setting {
+	return separatedListSetting(name, label, description, ",", v)
+}
+
+// A list setting whose items are separated by sep, for items that may hold commas
+func separatedListSetting(name, label, description, sep string, v *[]string) *setting {
+	return &setting{name, label, description,
+		func() interface{} { return *v },
+		func(arg string) error {
+			list := []string{}
+			for _, item := range strings.Split(arg, sep) {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			*v = list
+			return nil
+		}, "default", sep}
+}
+
+// A free form string setting
+func stringSetting(name, label, description string, v *string) *setting {
+	return &setting{name, label, description,
+		func() interface{} { return *v },
+		func(arg string) error {
+			*v = arg
+			return nil
+		}, "default", ""}
+}
+
+// Wrap a setting with an extra check on the values it accepts
+func checkedSetting(s *setting, check func(string) error) *setting {
+	set := s.set
+	s.set = func(arg string) error {
+		if err := check(arg); err != nil {
+			return err
+		}
+		return set(arg)
+	}
+	return s
+}
+
+// A string setting restricted to the given choices
+func choiceSetting(name, label, description string, v *string, choices []string) *setting {
+	return &setting{name, label, description,
+		func() interface{} { return *v },
+		func(arg string) error {
+			for _, c := range choices {
+				if arg == c {
+					*v = arg
+					return nil
+				}
+			}
+			return fmt.Errorf("%v must be one of %v", name, strings.Join(choices, ", "))
+		}, "default", ""}
+}
+
+var settings = []*setting{
+	boolSetting("case", "Case Sensitive", "If false, convert terms to lower case before indexing", &CaseSensitive),
+	boolSetting("indexanchors", "Index Anchors", "If true, index the titles of anchor tags", &IndexAnchorTitles),
+	choiceSetting("extract", "Extract", "What text of a page to index: full, or main to skip navigation, headers, footers and other boilerplate", &ExtractMode, extractModes),
+	boolSetting("indexalt", "Index Alt Text", "If true, index the alt text of images", &IndexAltText),
+	boolSetting("indexdescription", "Index Description", "If true, index the meta description of each page", &IndexDescription),
+	choiceSetting("domainpolicy", "Domain Policy", "Hosts links are followed to: host, domain, list or any", &DomainPolicy, domainPolicies),
+	listSetting("alloweddomains", "Allowed Domains", "Domains also followed by the list domain policy, comma separated", &AllowedDomains),
+	checkedSetting(listSetting("linksources", "Link Sources", "Kinds of tag links are followed from, comma separated: a, area, frame, iframe, link, refresh", &LinkSources),
+		func(arg string) error {
+			for _, source := range strings.Split(arg, ",") {
+				if source = strings.TrimSpace(source); source != "" && !isLinkSource(source) {
+					return fmt.Errorf("link source %v should be one of %v", source, strings.Join(linkSourceNames, ", "))
+				}
+			}
+			return nil
+		}),
+	listSetting("trackingparams", "Tracking Parameters", "Query parameters removed from urls, comma separated, * matches any characters", &TrackingParams),
+	boolSetting("mergewww", "Merge www", "If true, hosts with and without a leading www. are crawled as one site", &MergeWWW),
+	{"depth", "Maximum Depth", "How many levels of embedded links to crawl",
+		func() interface{} { return MaxDepth + 1 },
+		func(arg string) error {
+			i, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("%v not integer: %v", arg, err)
+			}
+			if i < 1 {
+				return fmt.Errorf("Depth must be greater than 0")
+			}
+			MaxDepth = i - 1
+			return nil
+		}, "default", ""},
+	intSetting("concurrency", "Concurrency", "How many concurrent pages to crawl", &Concurrency, 1),
+	intSetting("maxpages", "Maximum Pages", "Stop a crawl after this many pages, 0 for no limit", &MaxPages, 0),
+	intSetting("maxbytes", "Maximum Bytes", "Stop a crawl after downloading this many bytes, 0 for no limit", &MaxBytes, 0),
+	durationSetting("maxduration", "Maximum Duration", "Stop a crawl after this long, e.g. 10m, 0 for no limit", &MaxDuration),
+	intSetting("maxhostpages", "Maximum Host Pages", "Crawl at most this many pages from any one host, 0 for no limit", &MaxHostPages, 0),
+	intSetting("maxpathdepth", "Maximum Path Depth", "Links with more path segments than this are crawler traps, 0 for no limit", &MaxPathDepth, 0),
+	intSetting("maxsegmentrepeats", "Maximum Segment Repeats", "Links repeating a path segment more times than this are crawler traps, 0 for no limit", &MaxSegmentRepeats, 0),
+	intSetting("maxquerylength", "Maximum Query Length", "Links with a longer query string than this are crawler traps, 0 for no limit", &MaxQueryLength, 0),
+	intSetting("maxtemplateurls", "Maximum Template URLs", "Links beyond this many sharing a path template are crawler traps, 0 for no limit", &MaxTemplateURLs, 0),
+	stringSetting("checkpointdir", "Checkpoint Directory", "Save checkpoints of each crawl here so it can be resumed, empty for none", &CheckpointDir),
+	durationSetting("checkpointinterval", "Checkpoint Interval", "How often to save a checkpoint", &CheckpointInterval),
+	stringSetting("warcdir", "WARC Directory", "Archive every request and response of each crawl to WARC files here, empty for none", &WARCDir),
+	intSetting("warcmaxsize", "WARC Maximum Size", "Start a new WARC file once one is this many bytes, 0 for no limit", &WARCMaxSize, 0),
+	stringSetting("useragent", "User Agent", "The User-Agent header sent with each request", &UserAgent),
+	durationSetting("connecttimeout", "Connect Timeout", "How long to wait to connect to a server, 0 for no limit", &ConnectTimeout),
+	durationSetting("readtimeout", "Read Timeout", "How long to wait for a server to start responding or to send more of the body, 0 for no limit", &ReadTimeout),
+	durationSetting("totaltimeout", "Total Timeout", "How long a whole fetch may take, including the body, 0 for no limit", &TotalTimeout),
+	checkedSetting(separatedListSetting("headers", "Extra Headers", "Extra request headers, Name: value pairs separated by ;;", headerSeparator, &ExtraHeaders),
+		func(arg string) error {
+			for _, h := range strings.Split(arg, headerSeparator) {
+				if strings.TrimSpace(h) != "" && !strings.Contains(h, ":") {
+					return fmt.Errorf("header %v should be Name: value", strings.TrimSpace(h))
+				}
+			}
+			return nil
+		}),
+	checkedSetting(stringSetting("proxy", "Proxy", "HTTP(S) proxy url, empty to use the HTTP_PROXY environment", &ProxyURL),
+		func(arg string) error {
+			if arg == "" {
+				return nil
+			}
+			u, err := url.Parse(arg)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("proxy %v should be a url such as http://proxy:8080", arg)
+			}
+			return nil
+		}),
+	choiceSetting("transport", "Transport", "live fetches from the network, record also saves each response to fixturedir, replay serves them from there", &TransportMode, transportModes),
+	stringSetting("fixturedir", "Fixture Directory", "Where responses are recorded to and replayed from", &FixtureDir),
+	boolSetting("tlsinsecure", "TLS Insecure", "If true, do not verify server certificates", &TLSInsecure),
+	choiceSetting("tlsminversion", "TLS Minimum Version", "The oldest TLS version accepted: 1.0, 1.1, 1.2 or 1.3", &TLSMinVersion, tlsVersionNames),
+	intSetting("maxredirects", "Maximum Redirects", "How many redirects to follow for a page", &MaxRedirects, 0),
+	intSetting("maxbodysize", "Maximum Body Size", "The most bytes read from any page, 0 for no limit", &MaxBodySize, 0),
+	listSetting("fileinclude", "File Include", "Globs naming the local files indexed, comma separated, empty for all", &FileInclude),
+	listSetting("fileexclude", "File Exclude", "Globs naming local files and directories skipped, comma separated", &FileExclude),
+	intSetting("maxfilesize", "Maximum File Size", "Local files larger than this many bytes are skipped, 0 for no limit", &MaxFileSize, 0),
+	intSetting("maxtokens", "Maximum Tokens", "The most terms indexed from any page, 0 for no limit", &MaxTokens, 0),
+	intSetting("maxtermlength", "Maximum Term Length", "Terms longer than this many characters are not indexed, 0 for no limit", &MaxTermLength, 0),
+	intSetting("duplicatedistance", "Duplicate Distance", "Pages whose fingerprints differ in at most this many of 64 bits are near-duplicates, 0 to index every page", &DuplicateDistance, 0),
+	intSetting("maxattempts", "Maximum Attempts", "How many times in all to try a page that fails with an error that may be temporary", &RetryAttempts, 1),
+	durationSetting("retrybackoff", "Retry Backoff", "The wait before the first retry, doubling after each one", &RetryBackoff),
+	durationSetting("retrymaxwait", "Retry Maximum Wait", "The longest wait between retries, including waits asked for by Retry-After", &RetryMaxWait),
+	choiceSetting("output", "Output", "Format used for reports: text, json, csv or tsv", &OutputFormat, outputFormats),
+}
+
+func findSetting(name string) *setting {
+	for _, s := range settings {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// The file the config save command writes to, and where the config was loaded from
+var ConfigFile string
+
+// The standard location of the config file, used when no --config flag is given
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "searcher.toml"
+	}
+	return filepath.Join(dir, "searcher", "config.toml")
+}
+
+// Adapts a setting to the flag package so every setting is also a command line flag
+type settingFlag struct {
+	s *setting
+}
+
+func (f settingFlag) String() string {
+	if f.s == nil {
+		return ""
+	}
+	return f.s.value()
+}
+
+func (f settingFlag) Set(arg string) error {
+	if err := f.s.set(arg); err != nil {
+		return err
+	}
+	f.s.source = "flag"
+	return nil
+}
+
+func (f settingFlag) IsBoolFlag() bool {
+	return f.s.isBool()
+}
+
+// Parse the command line, then load the config file.  Values given as flags
+// take precedence over the file.
+func LoadConfig() {
+	configFlag := flag.String("config", "", "config file to load, default "+defaultConfigFile())
+	for _, s := range settings {
+		flag.Var(settingFlag{s}, s.name, s.description)
+	}
+	flag.Parse()
+
+	ConfigFile = *configFlag
+	if ConfigFile == "" {
+		ConfigFile = defaultConfigFile()
+		if _, err := os.Stat(ConfigFile); os.IsNotExist(err) {
+			return
+		}
+	}
+	if err := loadConfigFile(ConfigFile); err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config file %v: %v\n", ConfigFile, err)
+		os.Exit(2)
+	}
+}
+
+func loadConfigFile(path string) error {
+	var values map[string]interface{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return err
+	}
+	for name, value := range values {
+		s := findSetting(name)
+		if s == nil {
+			return fmt.Errorf("unknown setting %v", name)
+		}
+		if s.source == "flag" {
+			continue
+		}
+		if err := s.set(configValue(value, s.separator)); err != nil {
+			return err
+		}
+		s.source = "file"
+	}
+	return nil
+}
+
+// Convert a value read from the config file to the form the set command takes.
+// Arrays are joined with the separator of the list setting they are for.
+func configValue(value interface{}, sep string) string {
+	if list, ok := value.([]interface{}); ok {
+		items := []string{}
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, sep)
+	}
+	return fmt.Sprint(value)
+}
+
+// Write the current settings to path, or to the config file if path is empty
+func SaveConfig(path string) {
+	if path == "" {
+		path = ConfigFile
+	}
+	if path == "" {
+		path = defaultConfigFile()
+	}
+	values := make(map[string]interface{})
+	for _, s := range settings {
+		values[s.name] = s.get()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Printf("Unable to save config: %v\n", err)
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Unable to save config: %v\n", err)
+		return
+	}
+	fmt.Fprintf(f, "# searcher %v configuration\n", version)
+	if err := toml.NewEncoder(f).Encode(values); err != nil {
+		f.Close()
+		fmt.Printf("Unable to save config: %v\n", err)
+		return
+	}
+	// a failed write may only show up when the file is closed
+	if err := f.Close(); err != nil {
+		fmt.Printf("Unable to save config: %v\n", err)
+		return
+	}
+	ConfigFile = path
+	progressf("Configuration saved to %v\n", path)
+}
+
+// The config command: show the settings, or save them with config save [file]
+func Config(command string) {
+	commandArgs := strings.Fields(command)
+	if len(commandArgs) == 0 {
+		ShowConfig()
+		return
+	}
+	switch commandArgs[0] {
+	case "save":
+		path := ""
+		if len(commandArgs) > 1 {
+			path = commandArgs[1]
+		}
+		SaveConfig(path)
+	default:
+		fmt.Printf("config command takes no arguments, or save [file]\n")
+	}
+}
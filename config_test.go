@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Put the settings back as they were when the test ends
+func keepSettings(t *testing.T) {
+	values := make(map[string]string)
+	sources := make(map[string]string)
+	for _, s := range settings {
+		values[s.name], sources[s.name] = s.value(), s.source
+	}
+	t.Cleanup(func() {
+		for _, s := range settings {
+			s.set(values[s.name])
+			s.source = sources[s.name]
+		}
+	})
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// A value given as a flag is kept over the file's, and the rest come from the file
+func TestFlagOverridesConfigFile(t *testing.T) {
+	keepSettings(t)
+	if err := (settingFlag{findSetting("depth")}).Set("5"); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfigFile(t, "depth = 2\nconcurrency = 4\noutput = \"json\"\n")
+	if err := loadConfigFile(path); err != nil {
+		t.Fatalf("loadConfigFile failed: %v", err)
+	}
+	if MaxDepth != 4 || Concurrency != 4 || OutputFormat != "json" {
+		t.Errorf("got depth %v, concurrency %v, output %v, want 5, 4 and json", MaxDepth+1, Concurrency, OutputFormat)
+	}
+	if depth, concurrency := findSetting("depth").source, findSetting("concurrency").source; depth != "flag" || concurrency != "file" {
+		t.Errorf("depth came from %v and concurrency from %v, want flag and file", depth, concurrency)
+	}
+}
+
+// Saved settings load back to the same values
+func TestSaveThenLoadConfig(t *testing.T) {
+	keepSettings(t)
+	for name, value := range map[string]string{"case": "true", "depth": "6", "concurrency": "3", "output": "csv"} {
+		if err := findSetting(name).set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := make(map[string]string)
+	for _, s := range settings {
+		saved[s.name] = s.value()
+	}
+	path := filepath.Join(t.TempDir(), "searcher", "config.toml")
+	SaveConfig(path)
+	if ConfigFile != path {
+		t.Fatalf("config file is %v after saving to %v", ConfigFile, path)
+	}
+
+	for name, value := range map[string]string{"case": "false", "depth": "1", "concurrency": "10", "output": "text"} {
+		findSetting(name).set(value)
+	}
+	if err := loadConfigFile(path); err != nil {
+		t.Fatalf("loading the saved config failed: %v", err)
+	}
+	for _, s := range settings {
+		if got := s.value(); got != saved[s.name] {
+			t.Errorf("%v loaded as %v, saved as %v", s.name, got, saved[s.name])
+		}
+	}
+}
+
+func TestConfigFileUnknownSetting(t *testing.T) {
+	keepSettings(t)
+	path := writeConfigFile(t, "depth = 2\ncolour = \"red\"\n")
+	err := loadConfigFile(path)
+	if err == nil || !strings.Contains(err.Error(), "unknown setting colour") {
+		t.Errorf("got error %v, want unknown setting colour", err)
+	}
+}
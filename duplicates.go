@@ -0,0 +1,151 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"sort"
+)
+
+// Near-duplicate detection.  Each HTML page gets a 64 bit SimHash of its terms,
+// so pages with mostly the same terms get fingerprints differing in few bits.
+// With DuplicateDistance set, a page whose fingerprint is within that many bits
+// of a page already indexed joins that page's cluster and is not indexed itself,
+// which keeps print views and copies with session ids or tracking parameters out
+// of the results.  It is 0, indexing every page, unless set.
+// Pages are found by fingerprint through four 16 bit bands: fingerprints within
+// 3 bits of each other agree in at least one band, so a new page is only
+// compared with the pages sharing a band with it.  A DuplicateDistance over 3
+// compares it with every page.
+
+var DuplicateDistance = 0
+
+const fingerprintBands = 4
+
+// The SimHash of a page's terms, weighted by how often each occurs.  Returns 0
+// for a page with no terms, which is never treated as a duplicate.
+func simhash(terms map[string]int) uint64 {
+	if len(terms) == 0 {
+		return 0
+	}
+	var weights [64]int
+	for term, count := range terms {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit] += count
+			} else {
+				weights[bit] -= count
+			}
+		}
+	}
+	var fingerprint uint64
+	for bit, w := range weights {
+		if w > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+	return fingerprint
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func fingerprintBand(fingerprint uint64, band int) uint16 {
+	return uint16(fingerprint >> (16 * uint(band)))
+}
+
+// Whether a page can be the one a near-duplicate is a copy of
+func canBeOriginal(info PageInfo) bool {
+	return info.Fingerprint != 0 && info.DuplicateOf == ""
+}
+
+// Record a page, adding it to the fingerprint bands.  Called with ut.mux held.
+func (ut *URLtitles) setPage(url string, info PageInfo) {
+	old, ok := ut.titles[url]
+	ut.titles[url] = info
+	if !canBeOriginal(info) || ok && canBeOriginal(old) && old.Fingerprint == info.Fingerprint {
+		return
+	}
+	for band := range ut.bands {
+		if ut.bands[band] == nil {
+			ut.bands[band] = make(map[uint16][]string)
+		}
+		key := fingerprintBand(info.Fingerprint, band)
+		ut.bands[band][key] = append(ut.bands[band][key], url)
+	}
+}
+
+// The urls of the pages that may be within DuplicateDistance bits of a
+// fingerprint.  Pages changed since they were added to the bands are left for
+// the caller to check.  Called with ut.mux held.
+func (ut *URLtitles) duplicateCandidates(fingerprint uint64) []string {
+	var urls []string
+	if DuplicateDistance >= fingerprintBands {
+		for u := range ut.titles {
+			urls = append(urls, u)
+		}
+		return urls
+	}
+	for band := range ut.bands {
+		urls = append(urls, ut.bands[band][fingerprintBand(fingerprint, band)]...)
+	}
+	return urls
+}
+
+// Record a page unless it is a near-duplicate of a page already recorded, in
+// which case it is recorded as a duplicate of that page and its url returned.
+// Checking and recording under one lock keeps two copies crawled at the same
+// time from both being indexed.
+func (ut *URLtitles) AddPageUnlessDuplicate(url string, info PageInfo) string {
+	ut.mux.Lock()
+	defer ut.mux.Unlock()
+	if DuplicateDistance > 0 && info.Fingerprint != 0 {
+		best, bestDistance := "", DuplicateDistance+1
+		for _, u := range ut.duplicateCandidates(info.Fingerprint) {
+			other := ut.titles[u]
+			if u == url || !canBeOriginal(other) {
+				continue
+			}
+			if d := hammingDistance(info.Fingerprint, other.Fingerprint); d < bestDistance || d == bestDistance && u < best {
+				best, bestDistance = u, d
+			}
+		}
+		info.DuplicateOf = best
+	}
+	ut.setPage(url, info)
+	return info.DuplicateOf
+}
+
+// A page that was indexed and the near-duplicates of it that were not
+type duplicateCluster struct {
+	URL        string   `json:"url"`
+	Title      string   `json:"title"`
+	Duplicates []string `json:"duplicates"`
+}
+
+// The clusters of near-duplicate pages, largest first
+func (ut *URLtitles) Duplicates() []duplicateCluster {
+	ut.mux.Lock()
+	defer ut.mux.Unlock()
+	byURL := make(map[string][]string)
+	for u, info := range ut.titles {
+		if info.DuplicateOf != "" {
+			byURL[info.DuplicateOf] = append(byURL[info.DuplicateOf], u)
+		}
+	}
+	clusters := make([]duplicateCluster, 0, len(byURL))
+	for u, duplicates := range byURL {
+		sort.Strings(duplicates)
+		clusters = append(clusters, duplicateCluster{u, ut.titles[u].Title, duplicates})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Duplicates) != len(clusters[j].Duplicates) {
+			return len(clusters[i].Duplicates) > len(clusters[j].Duplicates)
+		}
+		return clusters[i].URL < clusters[j].URL
+	})
+	return clusters
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The banded lookup finds the same originals as comparing with every page
+func TestAddPageUnlessDuplicateBands(t *testing.T) {
+	distance := DuplicateDistance
+	defer func() { DuplicateDistance = distance }()
+	rng := rand.New(rand.NewSource(1))
+
+	for _, DuplicateDistance = range []int{1, 3} {
+		titles := newURLtitles()
+		var fingerprints []uint64
+		for i := 0; i < 2000; i++ {
+			fingerprint := rng.Uint64()
+			if i > 0 && i%3 == 0 {
+				// a near copy of an earlier page, some bits flipped
+				fingerprint = fingerprints[rng.Intn(len(fingerprints))]
+				for flips := rng.Intn(6); flips > 0; flips-- {
+					fingerprint ^= 1 << uint(rng.Intn(64))
+				}
+			}
+			fingerprints = append(fingerprints, fingerprint)
+			url := fmt.Sprintf("http://example.com/%04d", i)
+
+			want, wantDistance := "", DuplicateDistance+1
+			for u, other := range titles.titles {
+				if !canBeOriginal(other) {
+					continue
+				}
+				if d := hammingDistance(fingerprint, other.Fingerprint); d < wantDistance || d == wantDistance && u < want {
+					want, wantDistance = u, d
+				}
+			}
+			if got := titles.AddPageUnlessDuplicate(url, PageInfo{Fingerprint: fingerprint}); got != want {
+				t.Fatalf("distance %v: page %v is a duplicate of %q, want %q", DuplicateDistance, i, got, want)
+			}
+		}
+	}
+}
+
+// Unless a distance is set, copies of a page are all indexed
+func TestDuplicateDistanceOff(t *testing.T) {
+	distance := DuplicateDistance
+	defer func() { DuplicateDistance = distance }()
+	DuplicateDistance = 0
+	titles := newURLtitles()
+	titles.AddPageUnlessDuplicate("http://example.com/a", PageInfo{Fingerprint: 42})
+	if got := titles.AddPageUnlessDuplicate("http://example.com/b", PageInfo{Fingerprint: 42}); got != "" {
+		t.Errorf("copy recorded as a duplicate of %q", got)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Content extraction.  With set extract main, only the main content of a page is
+// indexed, so the navigation, footers and cookie banners repeated on every page
+// don't make terms like "privacy" or "login" match the whole site.  Body text is
+// gathered into blocks, one per paragraph, list item, cell and so on, skipping
+// <nav>, <header>, <footer> and <aside> and elements whose role, id or class
+// names them as boilerplate.  Then if the page has <main> or <article> elements
+// the blocks inside them are indexed, and otherwise the blocks with enough
+// words and few enough of them in links, as Readability does.  Ids and classes
+// on <html>, <body>, <main> and <article> are ignored, since they name the whole
+// page or its content, e.g. <body class="menu-open">.  A page where nothing
+// qualifies is indexed whole rather than not at all.
+
+var ExtractMode = "full"
+
+var extractModes = []string{"full", "main"}
+
+// Blocks outside <main> and <article> need this many words, and at most this
+// share of them in links, to count as main content
+const minBlockWords = 10
+const maxLinkDensity = 0.33
+
+// Blocks inside <main> and <article> are only dropped if they are mostly links
+const maxMainLinkDensity = 0.5
+
+// Elements always left out of the main content
+var boilerplateElements = map[string]bool{"nav": true, "header": true, "footer": true, "aside": true}
+
+// ARIA roles, and words in ids and class names, marking boilerplate
+var boilerplateRoles = map[string]bool{"navigation": true, "banner": true, "contentinfo": true, "complementary": true}
+var boilerplateNames = map[string]bool{
+	"nav": true, "navbar": true, "navigation": true, "menu": true, "header": true, "footer": true, "sidebar": true,
+	"cookie": true, "cookies": true, "consent": true, "banner": true, "breadcrumb": true, "breadcrumbs": true,
+	"share": true, "social": true,
+}
+
+// Elements holding the whole page or its content, never boilerplate whatever
+// their role, id or class
+var contentElements = map[string]bool{"html": true, "body": true, "main": true, "article": true}
+
+// Elements that start a new block of text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "li": true, "ul": true, "ol": true,
+	"dl": true, "dt": true, "dd": true, "table": true, "tr": true, "td": true, "th": true, "blockquote": true,
+	"pre": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "form": true, "br": true,
+}
+
+// The elements open at the current point of a page, for parsers that follow the
+// tokenizer rather than building a tree
+type elementStack []string
+
+func (s *elementStack) Push(name string) {
+	*s = append(*s, name)
+}
+
+// Close the innermost open name element, and any left open inside it.  Returns
+// false if no such element is open.
+func (s *elementStack) Pop(name string) bool {
+	i := len(*s) - 1
+	for i >= 0 && (*s)[i] != name {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	*s = (*s)[:i]
+	return true
+}
+
+// Whether a start tag opens an element that will be closed by an end tag
+func opensElement(token html.Token, selfClosing bool) bool {
+	return !selfClosing && !voidElements[token.Data]
+}
+
+type textBlock struct {
+	text                []string
+	words, linkWords    int
+	inMain, boilerplate bool
+}
+
+func (b *textBlock) linkDensity() float64 {
+	if b.words == 0 {
+		return 0
+	}
+	return float64(b.linkWords) / float64(b.words)
+}
+
+type contentExtractor struct {
+	open    elementStack
+	blocks  []*textBlock
+	current *textBlock
+	// the depth of the open element that started each state, 0 if not in it
+	skipDepth, mainDepth, linkDepth int
+	hasMain                         bool
+}
+
+func newContentExtractor() *contentExtractor {
+	return &contentExtractor{}
+}
+
+func isBoilerplate(token html.Token) bool {
+	if boilerplateElements[token.Data] {
+		return true
+	}
+	if contentElements[token.Data] {
+		return false
+	}
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "role":
+			if boilerplateRoles[strings.ToLower(attr.Val)] {
+				return true
+			}
+		case "id", "class":
+			words := strings.FieldsFunc(strings.ToLower(attr.Val), func(r rune) bool {
+				return r == ' ' || r == '-' || r == '_'
+			})
+			for _, w := range words {
+				if boilerplateNames[w] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (ce *contentExtractor) endBlock() {
+	if ce.current != nil && ce.current.words > 0 {
+		ce.blocks = append(ce.blocks, ce.current)
+	}
+	ce.current = nil
+}
+
+// Handle a start tag.  selfClosing tags and tags whose contents are skipped,
+// such as scripts, don't open an element.
+func (ce *contentExtractor) StartTag(token html.Token, selfClosing bool) {
+	if blockElements[token.Data] {
+		ce.endBlock()
+	}
+	if !opensElement(token, selfClosing) {
+		return
+	}
+	ce.open.Push(token.Data)
+	depth := len(ce.open)
+	if ce.skipDepth == 0 && isBoilerplate(token) {
+		ce.skipDepth = depth
+	}
+	if ce.mainDepth == 0 && (token.Data == "main" || token.Data == "article") {
+		ce.mainDepth = depth
+		ce.hasMain = true
+	}
+	if ce.linkDepth == 0 && token.Data == "a" {
+		ce.linkDepth = depth
+	}
+}
+
+func (ce *contentExtractor) EndTag(name string) {
+	if blockElements[name] {
+		ce.endBlock()
+	}
+	if !ce.open.Pop(name) {
+		return
+	}
+	depth := len(ce.open)
+	if ce.skipDepth > depth {
+		ce.skipDepth = 0
+	}
+	if ce.mainDepth > depth {
+		ce.mainDepth = 0
+		ce.endBlock()
+	}
+	if ce.linkDepth > depth {
+		ce.linkDepth = 0
+	}
+}
+
+// Add body text at the current point of the page.  Text in boilerplate is kept
+// in blocks of its own, only used if the page has nothing else.
+func (ce *contentExtractor) Text(text string) {
+	words := len(strings.Fields(text))
+	if words == 0 {
+		return
+	}
+	boilerplate := ce.skipDepth > 0
+	if ce.current != nil && ce.current.boilerplate != boilerplate {
+		ce.endBlock()
+	}
+	if ce.current == nil {
+		ce.current = &textBlock{inMain: ce.mainDepth > 0, boilerplate: boilerplate}
+	}
+	ce.current.text = append(ce.current.text, text)
+	ce.current.words += words
+	if ce.linkDepth > 0 {
+		ce.current.linkWords += words
+	}
+}
+
+// The text of the blocks making up the main content, in page order.  If no
+// block qualifies, as on a short page, all the text outside boilerplate is used,
+// and failing that all the text of the page.
+func (ce *contentExtractor) MainText() []string {
+	ce.endBlock()
+	var main, all, full []string
+	for _, b := range ce.blocks {
+		full = append(full, b.text...)
+		if b.boilerplate {
+			continue
+		}
+		all = append(all, b.text...)
+		switch {
+		case ce.hasMain:
+			if b.inMain && b.linkDensity() <= maxMainLinkDensity {
+				main = append(main, b.text...)
+			}
+		case b.words >= minBlockWords && b.linkDensity() <= maxLinkDensity:
+			main = append(main, b.text...)
+		}
+	}
+	if len(main) == 0 && len(all) == 0 {
+		return full
+	}
+	if len(main) == 0 {
+		return all
+	}
+	return main
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractMainContent(t *testing.T) {
+	tests := []struct {
+		name, body string
+		indexed    []string
+		skipped    []string
+	}{
+		{"menu open class on body",
+			`<body class="menu-open"><nav>home login</nav><p>Penguins waddle across the ice in long lines every single winter</p></body>`,
+			[]string{"penguins"}, []string{"login"}},
+		{"header id on a wrapper",
+			`<body><div id="page-header-wrapper"><p>Walruses</p></div></body>`,
+			[]string{"walruses"}, nil},
+		{"only boilerplate",
+			`<body><footer>Ostriches</footer></body>`,
+			[]string{"ostriches"}, nil},
+		{"main element",
+			`<body><div class="sidebar">Pelicans</div><main class="menu-layout"><p>Puffins</p></main></body>`,
+			[]string{"puffins"}, []string{"pelicans"}},
+	}
+
+	mode := ExtractMode
+	ExtractMode = "main"
+	defer func() { ExtractMode = mode }()
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "<html><head><title>%v</title></head>%v</html>", test.name, test.body)
+		}))
+		results := GetURL(server.URL)
+		server.Close()
+		if results.Err != nil {
+			t.Fatalf("%v: %v", test.name, results.Err)
+		}
+		for _, term := range test.indexed {
+			if results.Index[term] == 0 {
+				t.Errorf("%v: %v not indexed, got %v", test.name, term, results.Index)
+			}
+		}
+		for _, term := range test.skipped {
+			if results.Index[term] != 0 {
+				t.Errorf("%v: %v indexed", test.name, term)
+			}
+		}
+	}
+}
+
+// Self-closing scripts and style sheets don't hide the text after them
+func TestSelfClosingScript(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Zoo</title><script src="/app.js"/><style/></head>`+
+			`<body><p>Penguins</p><script>var walruses = 1;</script><p>Ostriches</p></body></html>`)
+	}))
+	defer server.Close()
+
+	results := GetURL(server.URL)
+	if results.Err != nil {
+		t.Fatal(results.Err)
+	}
+	if results.Title != "Zoo" {
+		t.Errorf("title %q, want Zoo", results.Title)
+	}
+	for term, want := range map[string]int{"penguins": 1, "ostriches": 1, "walruses": 0, "var": 0} {
+		if results.Index[term] != want {
+			t.Errorf("%v indexed %v times, want %v", term, results.Index[term], want)
+		}
+	}
+}
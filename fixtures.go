@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorded fixtures, so a crawl can be reproduced offline.  With set transport
+// record every http(s) response is fetched as usual and also saved to
+// FixtureDir, and with set transport replay the responses are served from there
+// without touching the network, byte for byte as they were recorded.  A request
+// with no fixture fails as a fetch error.
+//
+// Each url has its own fixture file, fixturedir/host/hash.warc, holding the
+// request and response records of each time it was fetched, in WARC format
+// uncompressed so they can be read and edited.  Replay serves the responses for
+// a url in the order they were recorded, repeating the last, so retries and
+// refetches at greater depth see what they saw when recorded.  Each crawl starts
+// again from the first response, and recording starts each url's fixture afresh
+// in each crawl.  Bodies are recorded up to MaxBodySize, and one byte more so
+// that replay sees a page was cut short just as the recorded crawl did.
+
+var TransportMode = "live"
+var FixtureDir = "fixtures"
+
+var transportModes = []string{"live", "record", "replay"}
+
+// The RoundTripper fetches go through, under the fixtures and WARC archiving.
+// nil is the network transport built from the client settings.
+var baseTransport http.RoundTripper
+
+// Plug in the RoundTripper used for fetches instead of the network, e.g. to
+// serve canned responses.  nil restores the network transport.
+func SetBaseTransport(rt http.RoundTripper) {
+	client.mux.Lock()
+	baseTransport = rt
+	client.c = nil
+	client.mux.Unlock()
+}
+
+// The responses recorded or replayed so far in this crawl for each fixture file
+var fixtureFetches = struct {
+	counts map[string]int
+	mux    sync.Mutex
+}{counts: make(map[string]int)}
+
+// Start replaying every url from its first response, and recording afresh
+func resetFixtureFetches() {
+	fixtureFetches.mux.Lock()
+	fixtureFetches.counts = make(map[string]int)
+	fixtureFetches.mux.Unlock()
+}
+
+type fixtureTransport struct {
+	dir    string
+	record bool
+	next   http.RoundTripper
+}
+
+func newFixtureTransport(mode, dir string, next http.RoundTripper) *fixtureTransport {
+	return &fixtureTransport{dir: dir, record: mode == "record", next: next}
+}
+
+// The fixture file for a request
+func (ft *fixtureTransport) fixtureFile(req *http.Request) string {
+	sum := sha1.Sum([]byte(req.Method + " " + req.URL.String()))
+	host := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(strings.ToLower(req.URL.Host))
+	return filepath.Join(ft.dir, host, fmt.Sprintf("%x.warc", sum[:10]))
+}
+
+func (ft *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return ft.next.RoundTrip(req)
+	}
+	if ft.dir == "" {
+		return nil, fmt.Errorf("fixturedir must be set to %v fixtures", TransportMode)
+	}
+	name := ft.fixtureFile(req)
+	if ft.record {
+		return ft.recordFixture(name, req)
+	}
+	return ft.replayFixture(name, req)
+}
+
+// Fetch the whole response and save it before handing it on
+func (ft *fixtureTransport) recordFixture(name string, req *http.Request) (*http.Response, error) {
+	requestBlock, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return nil, err
+	}
+	sent := time.Now()
+	resp, err := ft.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	limited := io.Reader(resp.Body)
+	if MaxBodySize > 0 {
+		limited = io.LimitReader(resp.Body, int64(MaxBodySize)+1)
+	}
+	body, err := io.ReadAll(limited)
+	truncated := ""
+	if err == nil && MaxBodySize > 0 && len(body) > MaxBodySize {
+		var probe [1]byte
+		if n, _ := resp.Body.Read(probe[:]); n > 0 {
+			truncated = "length"
+		}
+	}
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	request, response, responseBlock := warcExchange(requestBlock, resp, body, sent, truncated)
+	fixtureFetches.mux.Lock()
+	defer fixtureFetches.mux.Unlock()
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if fixtureFetches.counts[name] == 0 {
+		flags |= os.O_TRUNC
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, fmt.Errorf("unable to record fixture: %v", err)
+	}
+	f, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record fixture: %v", err)
+	}
+	defer f.Close()
+	if err := writeWARCRecord(f, request, requestBlock); err != nil {
+		return nil, fmt.Errorf("unable to record fixture: %v", err)
+	}
+	if err := writeWARCRecord(f, response, responseBlock); err != nil {
+		return nil, fmt.Errorf("unable to record fixture: %v", err)
+	}
+	fixtureFetches.counts[name]++
+	return resp, nil
+}
+
+// Serve the next recorded response for the request
+func (ft *fixtureTransport) replayFixture(name string, req *http.Request) (*http.Response, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no fixture for %v %v in %v", req.Method, req.URL, ft.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	wr, err := newWARCReader(f)
+	if err != nil {
+		return nil, err
+	}
+	type recorded struct {
+		block     []byte
+		truncated bool
+	}
+	var responses []recorded
+	for {
+		header, block, err := wr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad fixture %v: %v", name, err)
+		}
+		if header.Get("WARC-Type") == "response" {
+			data, err := io.ReadAll(block)
+			if err != nil {
+				return nil, fmt.Errorf("bad fixture %v: %v", name, err)
+			}
+			responses = append(responses, recorded{data, header.Get("WARC-Truncated") != ""})
+		}
+	}
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("no fixture for %v %v in %v", req.Method, req.URL, ft.dir)
+	}
+
+	fixtureFetches.mux.Lock()
+	i := fixtureFetches.counts[name]
+	fixtureFetches.counts[name]++
+	fixtureFetches.mux.Unlock()
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(responses[i].block)), req)
+	if err == nil && responses[i].truncated {
+		resp.Body = truncatedBody{resp.Body}
+	}
+	return resp, err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata/fixtures were recorded from testSite with
+//
+//	go test -run TestReplay -record
+var record = flag.Bool("record", false, "re-record testdata/fixtures from the test site")
+
+const fixtureSite = "http://www.example.com"
+const testFixtureDir = "testdata/fixtures"
+
+// A small site with a redirect, a missing page, a Markdown document and a page
+// that fails once before it works
+func testSite() http.Handler {
+	flaky := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<html><head><title>Example Home</title><meta name="description" content="The example site">
+</head><body><p>Welcome to the example site</p>
+<a href="/about.html">About</a> <a href="/old">Old about</a> <a href="/docs/guide.md">Guide</a>
+<a href="/flaky.html">Flaky</a> <a href="/missing.html">Missing</a></body></html>`)
+	})
+	mux.HandleFunc("/about.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>About Example</title></head><body><p>Founded by penguins</p></body></html>`)
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/about.html", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/docs/guide.md", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown")
+		fmt.Fprint(w, "# The Guide\n\nHow to feed walruses.  Back [home](/).\n")
+	})
+	mux.HandleFunc("/flaky.html", func(w http.ResponseWriter, r *http.Request) {
+		flaky++
+		if flaky == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `<html><head><title>Flaky</title></head><body><p>Eventually ostriches</p></body></html>`)
+	})
+	mux.HandleFunc("/big.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, strings.Repeat("lorem ipsum ", 500))
+	})
+	return mux
+}
+
+// Serves a handler in place of the network
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// Fetch from the checked in fixtures, or with -record record them afresh
+func useFixtures(t *testing.T) {
+	mode, dir, backoff := TransportMode, FixtureDir, RetryBackoff
+	TransportMode, FixtureDir, RetryBackoff = "replay", testFixtureDir, time.Millisecond
+	if *record {
+		TransportMode = "record"
+		SetBaseTransport(handlerTransport{testSite()})
+	}
+	resetFixtureFetches()
+	t.Cleanup(func() {
+		TransportMode, FixtureDir, RetryBackoff = mode, dir, backoff
+		SetBaseTransport(nil)
+	})
+}
+
+func newTestIndex() (*VisitedMap, *Index, *URLtitles) {
+	return &VisitedMap{v: make(map[string]int)}, &Index{entries: make(map[string][]IndexEntry)}, newURLtitles()
+}
+
+func indexedURLs(index *Index, term string) []string {
+	var urls []string
+	for _, entry := range index.GetTerm(term) {
+		urls = append(urls, entry.URL)
+	}
+	return urls
+}
+
+func TestReplayGetURL(t *testing.T) {
+	useFixtures(t)
+	results := GetURL(fixtureSite + "/")
+	if results.Err != nil {
+		t.Fatalf("GetURL failed: %v", results.Err)
+	}
+	if results.Status != http.StatusOK || results.Title != "Example Home" || results.Description != "The example site" {
+		t.Errorf("got status %v, title %q, description %q", results.Status, results.Title, results.Description)
+	}
+	for _, link := range []string{"/about.html", "/old", "/docs/guide.md", "/flaky.html", "/missing.html"} {
+		if _, ok := results.EmbeddedURL[fixtureSite+link]; !ok {
+			t.Errorf("link to %v not found in %v", link, results.EmbeddedURL)
+		}
+	}
+	if results.Index["welcome"] != 1 {
+		t.Errorf("welcome indexed %v times, want 1", results.Index["welcome"])
+	}
+}
+
+func TestReplayGetURLRedirect(t *testing.T) {
+	useFixtures(t)
+	results := GetURL(fixtureSite + "/old")
+	if results.Err != nil {
+		t.Fatalf("GetURL failed: %v", results.Err)
+	}
+	if results.FinalURL != fixtureSite+"/about.html" || results.Title != "About Example" {
+		t.Errorf("got final url %v and title %q, want the about page", results.FinalURL, results.Title)
+	}
+}
+
+func TestReplayMissingFixture(t *testing.T) {
+	if *record {
+		t.Skip("only replays")
+	}
+	useFixtures(t)
+	results := GetURL(fixtureSite + "/never-recorded.html")
+	if results.Err == nil || !strings.Contains(results.Err.Error(), "no fixture") {
+		t.Errorf("got error %v, want no fixture", results.Err)
+	}
+}
+
+func TestReplayCrawl(t *testing.T) {
+	useFixtures(t)
+	visited, index, titles := newTestIndex()
+	results := Crawl(fixtureSite+"/", 2, 1, visited, index, titles)
+
+	if results.uniquePages != 6 {
+		t.Errorf("crawled %v pages, want 6", results.uniquePages)
+	}
+	if len(results.errors) != 1 || results.errors[0].URL != fixtureSite+"/missing.html" {
+		t.Errorf("got errors %v, want only missing.html", results.errors)
+	}
+	terms := map[string]string{
+		"penguins":  fixtureSite + "/about.html",
+		"walruses":  fixtureSite + "/docs/guide.md",
+		"ostriches": fixtureSite + "/flaky.html",
+		"welcome":   fixtureSite + "/",
+	}
+	for term, url := range terms {
+		if got := indexedURLs(index, term); !reflect.DeepEqual(got, []string{url}) {
+			t.Errorf("%v found on %v, want %v", term, got, url)
+		}
+	}
+	if title, _ := titles.Get(fixtureSite + "/docs/guide.md"); title != "The Guide" {
+		t.Errorf("guide title %q, want The Guide", title)
+	}
+}
+
+// Each crawl replays every url from its first recorded response
+func TestReplayRestartsEachCrawl(t *testing.T) {
+	if *record {
+		t.Skip("only replays")
+	}
+	useFixtures(t)
+	attempts := RetryAttempts
+	RetryAttempts = 1
+	defer func() { RetryAttempts = attempts }()
+
+	for i := 0; i < 2; i++ {
+		visited, index, titles := newTestIndex()
+		results := Crawl(fixtureSite+"/", 2, 1, visited, index, titles)
+		failed := false
+		for _, e := range results.errors {
+			failed = failed || e.URL == fixtureSite+"/flaky.html"
+		}
+		if !failed {
+			t.Errorf("crawl %v: flaky.html didn't fail first, got errors %v", i+1, results.errors)
+		}
+	}
+}
+
+// A recorded crawl replays to the same summary and index, and bodies are only
+// recorded up to MaxBodySize
+func TestRecordThenReplay(t *testing.T) {
+	mode, dir, backoff, maxBody := TransportMode, FixtureDir, RetryBackoff, MaxBodySize
+	defer func() {
+		TransportMode, FixtureDir, RetryBackoff, MaxBodySize = mode, dir, backoff, maxBody
+		SetBaseTransport(nil)
+	}()
+	TransportMode, FixtureDir, RetryBackoff, MaxBodySize = "record", t.TempDir(), time.Millisecond, 1000
+	SetBaseTransport(handlerTransport{testSite()})
+
+	visited, recordedIndex, titles := newTestIndex()
+	recorded := Crawl(fixtureSite+"/", 2, 1, visited, recordedIndex, titles)
+	big := GetURL(fixtureSite + "/big.txt")
+
+	TransportMode = "replay"
+	SetBaseTransport(nil)
+	visited, replayedIndex, titles := newTestIndex()
+	replayed := Crawl(fixtureSite+"/", 2, 1, visited, replayedIndex, titles)
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("replayed crawl %+v, recorded %+v", replayed, recorded)
+	}
+	if !reflect.DeepEqual(recordedIndex.entries, replayedIndex.entries) {
+		t.Errorf("replayed index differs from the recorded one")
+	}
+
+	replayedBig := GetURL(fixtureSite + "/big.txt")
+	if big.Truncated != "maxbodysize" || replayedBig.Truncated != "maxbodysize" {
+		t.Errorf("big.txt truncated %q when recorded and %q when replayed, want maxbodysize", big.Truncated, replayedBig.Truncated)
+	}
+	files, _ := filepath.Glob(filepath.Join(FixtureDir, "*", "*.warc"))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.Size() > 4000 {
+			t.Errorf("fixture %v is %v bytes, more than maxbodysize allows", f, info.Size())
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// The crawl frontier: a priority queue of URLs waiting to be crawled, feeding a
+// fixed pool of workers.  Requests are taken shallowest first, then by priority,
+// where a request's priority is the priority it was queued with (e.g. from a
+// sitemap) plus the number of times it has been linked to while waiting.  Ties
+// are taken in the order they were queued.  A URL is queued once, under its
+// visitedKey.
+//
+// The crawl is finished when the queue is empty and no worker is processing a
+// request, since only a worker in progress can add more.
+
+type frontierItem struct {
+	request crawlRequest
+	key     string
+	inlinks int
+	seq     int
+	index   int
+}
+
+func (fi *frontierItem) priority() float64 {
+	return fi.request.priority + float64(fi.inlinks)
+}
+
+type requestQueue []*frontierItem
+
+func (q requestQueue) Len() int { return len(q) }
+
+func (q requestQueue) Less(i, j int) bool {
+	if q[i].request.depth != q[j].request.depth {
+		return q[i].request.depth < q[j].request.depth
+	}
+	if q[i].priority() != q[j].priority() {
+		return q[i].priority() > q[j].priority()
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q requestQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *requestQueue) Push(x interface{}) {
+	item := x.(*frontierItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *requestQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+type frontier struct {
+	queue      requestQueue
+	queued     map[string]*frontierItem
+	inProgress int
+	seq        int
+	closed     bool
+	paused     bool
+	mux        sync.Mutex
+	cond       *sync.Cond
+}
+
+func newFrontier() *frontier {
+	f := &frontier{queued: make(map[string]*frontierItem)}
+	f.cond = sync.NewCond(&f.mux)
+	return f
+}
+
+// Queue a request.  A URL already waiting in the queue is not queued twice,
+// instead the waiting request gains an inlink and takes the shallower depth.
+func (f *frontier) Push(r crawlRequest) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	key := visitedKey(r.url)
+	if item, ok := f.queued[key]; ok {
+		item.inlinks++
+		if r.depth < item.request.depth {
+			item.request.depth = r.depth
+		}
+		if r.priority > item.request.priority {
+			item.request.priority = r.priority
+		}
+		heap.Fix(&f.queue, item.index)
+		return
+	}
+	f.seq++
+	item := &frontierItem{request: r, key: key, seq: f.seq}
+	heap.Push(&f.queue, item)
+	f.queued[key] = item
+	f.cond.Signal()
+}
+
+// Take the next request, waiting while other workers may still add more.
+// Returns false when the crawl is finished or has been closed.  Each request
+// taken must be followed by a call to Done.
+func (f *frontier) Pop() (crawlRequest, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for (f.paused || len(f.queue) == 0 && f.inProgress > 0) && !f.closed {
+		f.cond.Wait()
+	}
+	if len(f.queue) == 0 || f.closed {
+		// wake up the other waiting workers so they can finish too
+		f.cond.Broadcast()
+		return crawlRequest{}, false
+	}
+	item := heap.Pop(&f.queue).(*frontierItem)
+	delete(f.queued, item.key)
+	f.inProgress++
+	return item.request, true
+}
+
+// Mark a request taken by Pop as finished
+func (f *frontier) Done() {
+	f.mux.Lock()
+	f.inProgress--
+	f.mux.Unlock()
+	f.cond.Broadcast()
+}
+
+// Stop the crawl.  Requests in progress finish, and the waiting requests and
+// any they add are kept for the final checkpoint, but none are handed out.
+func (f *frontier) Close() {
+	f.mux.Lock()
+	f.closed = true
+	f.mux.Unlock()
+	f.cond.Broadcast()
+}
+
+// The number of requests waiting
+func (f *frontier) Len() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.queue)
+}
+
+// Stop handing out requests and wait for those in progress to finish, so the
+// crawl is in a consistent state, e.g. for a checkpoint.
+func (f *frontier) Pause() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.paused = true
+	for f.inProgress > 0 {
+		f.cond.Wait()
+	}
+}
+
+func (f *frontier) Unpause() {
+	f.mux.Lock()
+	f.paused = false
+	f.mux.Unlock()
+	f.cond.Broadcast()
+}
+
+// The requests waiting, with the inlinks found so far added to their priority
+func (f *frontier) Snapshot() []crawlRequest {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	requests := make([]crawlRequest, 0, len(f.queue))
+	for _, item := range f.queue {
+		r := item.request
+		r.priority = item.priority()
+		requests = append(requests, r)
+	}
+	return requests
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Requests are taken shallowest first, then by priority plus inlinks, then in
+// the order they were queued
+func TestFrontierOrder(t *testing.T) {
+	f := newFrontier()
+	f.Push(crawlRequest{"deep", 2, 10})
+	f.Push(crawlRequest{"first", 1, 0})
+	f.Push(crawlRequest{"second", 1, 0})
+	f.Push(crawlRequest{"sitemap", 1, 1.5})
+	f.Push(crawlRequest{"linked", 1, 0})
+	f.Push(crawlRequest{"linked", 1, 0})
+	f.Push(crawlRequest{"linked", 1, 0})
+	f.Push(crawlRequest{"root", 0, 0})
+	// queued again shallower, the waiting request moves up
+	f.Push(crawlRequest{"deep", 0, 0})
+	if f.Len() != 6 {
+		t.Errorf("%v requests waiting, want 6", f.Len())
+	}
+
+	var got []string
+	for f.Len() > 0 {
+		r, _ := f.Pop()
+		got = append(got, r.url)
+		f.Done()
+	}
+	want := []string{"deep", "root", "linked", "sitemap", "first", "second"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+}
+
+// Runs workers over the frontier, each request at depth below 2 adding two more
+func runFrontierWorkers(f *frontier, workers int) (int, bool) {
+	var mux sync.Mutex
+	crawled := 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				r, ok := f.Pop()
+				if !ok {
+					return
+				}
+				time.Sleep(time.Millisecond)
+				if r.depth < 2 {
+					for j := 0; j < 2; j++ {
+						f.Push(crawlRequest{fmt.Sprintf("%v/%v", r.url, j), r.depth + 1, 0})
+					}
+				}
+				mux.Lock()
+				crawled++
+				mux.Unlock()
+				f.Done()
+			}
+		}()
+	}
+	finished := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return crawled, true
+	case <-time.After(5 * time.Second):
+		return crawled, false
+	}
+}
+
+// Idle workers wait while others may add requests, and all stop once the queue
+// is empty and none is in progress
+func TestFrontierFinishesWithIdleWorkers(t *testing.T) {
+	f := newFrontier()
+	f.Push(crawlRequest{"root", 0, 0})
+	crawled, finished := runFrontierWorkers(f, 8)
+	if !finished {
+		t.Fatalf("workers still waiting after %v requests", crawled)
+	}
+	if crawled != 7 {
+		t.Errorf("crawled %v requests, want 7", crawled)
+	}
+}
+
+// Close releases the waiting workers and hands out no more requests, but keeps
+// those waiting and any added later for the final checkpoint
+func TestFrontierClose(t *testing.T) {
+	f := newFrontier()
+	f.Push(crawlRequest{"root", 0, 0})
+	f.Push(crawlRequest{"other", 0, 0})
+	if _, ok := f.Pop(); !ok {
+		t.Fatal("no request to take")
+	}
+
+	released := make(chan bool)
+	go func() {
+		f.Pop()
+		_, ok := f.Pop()
+		released <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+	f.Close()
+	select {
+	case ok := <-released:
+		if ok {
+			t.Errorf("took a request after the frontier was closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting worker not released by Close")
+	}
+	f.Push(crawlRequest{"late", 1, 0})
+	if f.Len() != 1 {
+		t.Errorf("%v requests waiting after Close, want the one added", f.Len())
+	}
+	if _, ok := f.Pop(); ok {
+		t.Errorf("took a request after the frontier was closed")
+	}
+	f.Done()
+}
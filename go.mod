@@ -0,0 +1,10 @@
+module github.com/jimat9909/searcher
+
+go 1.25.0
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
+	golang.org/x/net v0.57.0
+	golang.org/x/text v0.40.0
+)
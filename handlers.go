@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// Content handlers parse a fetched document of one MIME type into the fields
+// of UrlParseResults, so Crawl and the Index treat every kind of document the
+// same way.  A handler indexes the text of the document and records its links
+// through the document, and may fill in the title, charset, description,
+// metadata and robots directives of the results.  Documents of a type with no
+// handler are recorded but not indexed.
+
+type contentHandler func(doc *document, results *UrlParseResults) error
+
+var contentHandlers = map[string]contentHandler{
+	"text/html":             parseHTML,
+	"application/xhtml+xml": parseHTML,
+	"text/plain":            parseText,
+	"text/markdown":         parseMarkdown,
+	"text/x-markdown":       parseMarkdown,
+	"application/pdf":       parsePDF,
+}
+
+// Servers often send these for any file they don't recognise, so the file
+// extension is used instead
+var genericTypes = map[string]bool{"application/octet-stream": true, "text/plain": true, "binary/octet-stream": true}
+
+var extensionTypes = map[string]string{
+	".txt":      "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".pdf":      "application/pdf",
+}
+
+// The handler for a document with the given Content-Type, fetched from u
+func handlerFor(contentType string, u *url.URL) (contentHandler, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	if genericTypes[mediaType] && u != nil {
+		if byExtension, ok := extensionTypes[strings.ToLower(path.Ext(u.Path))]; ok {
+			mediaType = byExtension
+		}
+	}
+	handler, ok := contentHandlers[mediaType]
+	return handler, ok
+}
+
+// A fetched document being parsed, and the terms and links found in it so far
+type document struct {
+	body        *bufio.Reader
+	contentType string
+	base        *url.URL
+	links       map[string]int
+	linkSources map[string][]string
+	terms       map[string]int
+	termCount   int
+	truncated   bool
+}
+
+func newDocument(body *bufio.Reader, contentType string, base *url.URL) *document {
+	return &document{body: body, contentType: contentType, base: base,
+		links: make(map[string]int), linkSources: make(map[string][]string), terms: make(map[string]int)}
+}
+
+// Record a link, resolved against the document url, and the kind of tag it was
+// found in
+func (d *document) AddLink(href, source string) {
+	link, ok := resolveLink(d.base, href)
+	if !ok {
+		return
+	}
+	d.links[link]++
+	for _, s := range d.linkSources[link] {
+		if s == source {
+			return
+		}
+	}
+	d.linkSources[link] = append(d.linkSources[link], source)
+}
+
+// Index text until the document reaches MaxTokens, which marks it truncated
+func (d *document) IndexText(s string) {
+	if MaxTokens > 0 && d.termCount >= MaxTokens {
+		d.truncated = true
+		return
+	}
+	d.termCount += addToURLIndex(s, d.terms, MaxTokens-d.termCount)
+	if MaxTokens > 0 && d.termCount >= MaxTokens {
+		d.truncated = true
+	}
+}
+
+// The body transcoded to UTF-8 from the charset in a byte order mark, the
+// Content-Type header or a <meta> tag near the start, or else guessed from the
+// text itself.  The guess only sees the first 1024 bytes, and takes them for
+// windows-1252 if they are plain ASCII, so a body that is valid UTF-8 as a whole
+// is read as UTF-8 unless the header or byte order mark says otherwise.
+func (d *document) utf8Body(results *UrlParseResults) io.Reader {
+	start, _ := d.body.Peek(1024)
+	encoding, charsetName, certain := charset.DetermineEncoding(start, d.contentType)
+	if certain {
+		results.Charset = charsetName
+		return transform.NewReader(d.body, encoding.NewDecoder())
+	}
+	// the body is bounded by maxbodysize, so it can be read ahead to check it
+	data, err := io.ReadAll(d.body)
+	body := io.Reader(bytes.NewReader(data))
+	if err != nil {
+		body = io.MultiReader(body, failedReader{err})
+	}
+	if validUTF8(data) {
+		results.Charset = "utf-8"
+		return body
+	}
+	results.Charset = charsetName
+	return transform.NewReader(body, encoding.NewDecoder())
+}
+
+// Whether data is UTF-8, but for a character cut off at the end of a body cut short
+func validUTF8(data []byte) bool {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				data = data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(data)
+}
+
+// Returns the error that ended a body read ahead, once the body has been read
+type failedReader struct {
+	err error
+}
+
+func (r failedReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// Index each line of a text document
+func indexLines(doc *document, r io.Reader, line func(string) string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if text := line(scanner.Text()); strings.TrimSpace(text) != "" {
+			doc.IndexText(text)
+		}
+	}
+	return scanner.Err()
+}
+
+// The content handler for plain text: every line is indexed
+func parseText(doc *document, results *UrlParseResults) error {
+	return indexLines(doc, doc.utf8Body(results), func(line string) string { return line })
+}
+
+var (
+	markdownHeading  = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]*)[^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]*)[^)]*\)`)
+	markdownRefLink  = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s*(\S+)`)
+	markdownAutolink = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	markdownMarkup   = strings.NewReplacer("**", "", "__", "", "*", "", "`", "", "~~", "", ">", "")
+)
+
+// The content handler for Markdown: the text is indexed without its markup, the
+// first heading is the title, and links and image alt text are treated like
+// <a href> and <img alt> in HTML
+func parseMarkdown(doc *document, results *UrlParseResults) error {
+	title := ""
+	return indexLines(doc, doc.utf8Body(results), func(line string) string {
+		if m := markdownRefLink.FindStringSubmatch(line); m != nil {
+			doc.AddLink(m[1], "a")
+			return ""
+		}
+		if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			line = m[1]
+			if title == "" {
+				title = markdownMarkup.Replace(line)
+				results.Title = title
+			}
+		}
+		line = markdownImage.ReplaceAllStringFunc(line, func(image string) string {
+			if !IndexAltText {
+				return ""
+			}
+			return markdownImage.FindStringSubmatch(image)[1]
+		})
+		line = markdownLink.ReplaceAllStringFunc(line, func(link string) string {
+			m := markdownLink.FindStringSubmatch(link)
+			doc.AddLink(m[2], "a")
+			return m[1]
+		})
+		for _, m := range markdownAutolink.FindAllStringSubmatch(line, -1) {
+			doc.AddLink(m[1], "a")
+		}
+		return markdownMarkup.Replace(line)
+	})
+}
+
+// The content handler for PDF: the text of every page is indexed, and the title
+// and author come from the document information
+func parsePDF(doc *document, results *UrlParseResults) (err error) {
+	// the pdf package panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unreadable pdf: %v", r)
+		}
+	}()
+	data, err := io.ReadAll(doc.body)
+	if err != nil {
+		return err
+	}
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("unreadable pdf: %v", err)
+	}
+	info := reader.Trailer().Key("Info")
+	if title := strings.TrimSpace(info.Key("Title").Text()); title != "" {
+		results.Title = title
+	}
+	if author := info.Key("Author").Text(); author != "" {
+		results.Meta = map[string][]string{"author": {strings.TrimSpace(author)}}
+	}
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				doc.IndexText(line)
+			}
+		}
+		if doc.truncated {
+			break
+		}
+	}
+	return nil
+}
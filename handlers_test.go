@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A one page PDF with the given text, title and author
+func testPDF(text, title, author string) []byte {
+	content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%v) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %v >>\nstream\n%v\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Title (%v) /Author (%v) >>", title, author),
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	var offsets []int
+	for i, obj := range objects {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%v 0 obj\n%v\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %v\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %v /Root 1 0 R /Info 6 0 R >>\nstartxref\n%v\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func handlerSite() *httptest.Server {
+	serve := func(contentType, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			fmt.Fprint(w, body)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/notes.txt", serve("text/plain; charset=utf-8", "Penguins huddle\n\nagainst the cold, see http://example.com/ice\n"))
+	mux.HandleFunc("/guide", serve("text/markdown", `# The **Walrus** Guide #
+
+Walruses eat [clams](/clams.html "Clams") and ![a diving walrus](/dive.jpg) dive.
+See <https://example.org/tusks> and [the ref][tusks].
+
+[tusks]: /tusks.html
+`))
+	mux.HandleFunc("/report.pdf", serve("application/pdf", string(testPDF("Narwhals sing", "Narwhal Report", "Ada"))))
+	mux.HandleFunc("/download.pdf", serve("application/octet-stream", string(testPDF("Puffins dive", "Puffin Report", "Bo"))))
+	mux.HandleFunc("/broken.pdf", serve("application/pdf", "%PDF-1.4\nnot really a pdf\n"))
+	mux.HandleFunc("/readme.md", serve("text/plain", "# Readme\n\nOstriches run\n"))
+	return httptest.NewServer(mux)
+}
+
+func TestContentHandlers(t *testing.T) {
+	server := handlerSite()
+	defer server.Close()
+	tests := []struct {
+		path, title string
+		terms       []string
+		links       []string
+		author      string
+	}{
+		{"/notes.txt", "", []string{"penguins", "huddle", "cold"}, nil, ""},
+		{"/guide", "The Walrus Guide", []string{"walrus", "walruses", "clams", "diving", "dive"},
+			[]string{server.URL + "/clams.html", "https://example.org/tusks", server.URL + "/tusks.html"}, ""},
+		{"/report.pdf", "Narwhal Report", []string{"narwhals", "sing"}, nil, "Ada"},
+		// a generic type is handled by its extension
+		{"/download.pdf", "Puffin Report", []string{"puffins"}, nil, "Bo"},
+		{"/readme.md", "Readme", []string{"ostriches"}, nil, ""},
+	}
+	for _, test := range tests {
+		results := GetURL(server.URL + test.path)
+		if results.Err != nil || !results.Parsed() {
+			t.Errorf("%v failed to parse: %v", test.path, results.Err)
+			continue
+		}
+		title := test.title
+		if title == "" {
+			// with no title of its own a document is titled by its url
+			title = server.URL + test.path
+		}
+		if results.Title != title {
+			t.Errorf("%v has title %q, want %q", test.path, results.Title, title)
+		}
+		for _, term := range test.terms {
+			if results.Index[term] == 0 {
+				t.Errorf("%v didn't index %v, got %v", test.path, term, results.Index)
+			}
+		}
+		if len(results.EmbeddedURL) != len(test.links) {
+			t.Errorf("%v has links %v, want %v", test.path, results.EmbeddedURL, test.links)
+		}
+		for _, link := range test.links {
+			if results.EmbeddedURL[link] == 0 {
+				t.Errorf("%v has links %v, want %v", test.path, results.EmbeddedURL, link)
+			}
+		}
+		if author := strings.Join(results.Meta["author"], ","); author != test.author {
+			t.Errorf("%v has author %q, want %q", test.path, author, test.author)
+		}
+	}
+}
+
+func TestUnreadablePDF(t *testing.T) {
+	server := handlerSite()
+	defer server.Close()
+	results := GetURL(server.URL + "/broken.pdf")
+	if results.Err == nil || !strings.Contains(results.Err.Error(), "unreadable pdf") || results.Parsed() {
+		t.Errorf("got error %v, want unreadable pdf", results.Err)
+	}
+}
+
+// A page with no declared charset is read as UTF-8 if it is valid UTF-8, even
+// when its first 1024 bytes are plain ASCII, and a declared charset is honored
+func TestCharsetDetection(t *testing.T) {
+	filler := "<p>" + strings.Repeat("filler ", 200) + "</p>"
+	pages := map[string]struct{ contentType, body string }{
+		"/undeclared.html": {"text/html", "<html><body>" + filler + "<p>café naïve</p></body></html>"},
+		"/undeclared.txt":  {"text/plain", strings.Repeat("filler ", 200) + "\ncafé naïve\n"},
+		"/header.html":     {"text/html; charset=windows-1252", "<html><body>" + filler + "<p>caf\xe9 na\xefve</p></body></html>"},
+		"/meta.html":       {"text/html", `<html><head><meta charset="windows-1252"></head><body>` + filler + "<p>caf\xe9 na\xefve</p></body></html>"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := pages[r.URL.Path]
+		w.Header().Set("Content-Type", page.contentType)
+		fmt.Fprint(w, page.body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path, charset string
+	}{
+		{"/undeclared.html", "utf-8"},
+		{"/undeclared.txt", "utf-8"},
+		{"/header.html", "windows-1252"},
+		{"/meta.html", "windows-1252"},
+	}
+	for _, test := range tests {
+		results := GetURL(server.URL + test.path)
+		if results.Err != nil {
+			t.Fatalf("GetURL(%v) failed: %v", test.path, results.Err)
+		}
+		if results.Charset != test.charset || results.Index["café"] != 1 || results.Index["naïve"] != 1 {
+			t.Errorf("%v read as %v, indexing café %v and naïve %v times, want %v and once each",
+				test.path, results.Charset, results.Index["café"], results.Index["naïve"], test.charset)
+		}
+	}
+}
+
+// A character cut off at the end of a body doesn't stop it being UTF-8
+func TestValidUTF8(t *testing.T) {
+	tests := map[string]bool{
+		"plain ascii":   true,
+		"café":          true,
+		"caf\xc3":       true,
+		"caf\xe2\x82":   true,
+		"caf\xe9 later": false,
+		"\xc3":          true,
+	}
+	for s, want := range tests {
+		if got := validUTF8([]byte(s)); got != want {
+			t.Errorf("validUTF8(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
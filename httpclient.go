@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP client used for every fetch.  It is built from the settings below and
+// rebuilt when any of them change.  ReadTimeout limits both the wait for the
+// response headers and each wait for more of the body, so a server that stalls
+// partway through a page is given up on too.
+
+var UserAgent = "searcher/" + version
+var ConnectTimeout = 10 * time.Second
+var ReadTimeout = 30 * time.Second
+var TotalTimeout = 60 * time.Second
+var ExtraHeaders []string
+var ProxyURL = ""
+var TLSInsecure = false
+var TLSMinVersion = "1.2"
+var MaxRedirects = 10
+
+// Separates the extra headers in the headers setting, since header values may
+// hold commas
+const headerSeparator = ";;"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsVersionNames = []string{"1.0", "1.1", "1.2", "1.3"}
+
+// The settings a client was built with
+type clientConfig struct {
+	connectTimeout, readTimeout, totalTimeout time.Duration
+	proxy                                     string
+	tlsInsecure                               bool
+	tlsMinVersion                             string
+	maxRedirects, concurrency                 int
+	transportMode, fixtureDir                 string
+}
+
+func currentClientConfig() clientConfig {
+	return clientConfig{ConnectTimeout, ReadTimeout, TotalTimeout, ProxyURL, TLSInsecure, TLSMinVersion, MaxRedirects, Concurrency, TransportMode, FixtureDir}
+}
+
+var client struct {
+	config clientConfig
+	c      *http.Client
+	mux    sync.Mutex
+}
+
+// The client for the current settings
+func HTTPClient() *http.Client {
+	client.mux.Lock()
+	defer client.mux.Unlock()
+	config := currentClientConfig()
+	if client.c == nil || client.config != config {
+		client.c = newHTTPClient(config)
+		client.config = config
+	}
+	return client.c
+}
+
+func newHTTPClient(config clientConfig) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if config.proxy != "" {
+		// the proxy setting is checked when it is set
+		proxyURL, _ := url.Parse(config.proxy)
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: config.connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   config.connectTimeout,
+		ResponseHeaderTimeout: config.readTimeout,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: config.tlsInsecure,
+			MinVersion:         tlsVersions[config.tlsMinVersion],
+		},
+		MaxIdleConnsPerHost: config.concurrency,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	transport.RegisterProtocol("file", fileTransport{})
+	var roundTripper http.RoundTripper = transport
+	if baseTransport != nil {
+		roundTripper = baseTransport
+	}
+	if config.transportMode != "live" {
+		roundTripper = newFixtureTransport(config.transportMode, config.fixtureDir, roundTripper)
+	}
+	maxRedirects := config.maxRedirects
+	return &http.Client{
+		Transport: warcTransport{roundTripper},
+		Timeout:   config.totalTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %v redirects", maxRedirects)
+			}
+			if req.URL.Scheme == "file" && via[0].URL.Scheme != "file" {
+				return fmt.Errorf("not following redirect to local file %v", req.URL)
+			}
+			return nil
+		},
+	}
+}
+
+// Build a GET request carrying the user agent and extra headers
+func newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	for _, h := range ExtraHeaders {
+		if name, value, ok := strings.Cut(h, ":"); ok {
+			req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	return req, nil
+}
+
+// Fetch url with the configured client, within ctx
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := newRequest(url)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := HTTPClient().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &stallTimeoutBody{body: resp.Body, timeout: ReadTimeout, cancel: cancel}
+	return resp, nil
+}
+
+// A response body that cancels the fetch when a read waits longer than timeout,
+// if timeout is more than 0
+type stallTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+	stalled bool
+	mux     sync.Mutex
+}
+
+func (b *stallTimeoutBody) Read(p []byte) (int, error) {
+	if b.timeout <= 0 {
+		return b.body.Read(p)
+	}
+	timer := time.AfterFunc(b.timeout, func() {
+		b.mux.Lock()
+		b.stalled = true
+		b.mux.Unlock()
+		b.cancel()
+	})
+	n, err := b.body.Read(p)
+	timer.Stop()
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.stalled && err != nil {
+		err = fmt.Errorf("no data for %v reading the body: %w", b.timeout, err)
+	}
+	return n, err
+}
+
+func (b *stallTimeoutBody) Close() error {
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
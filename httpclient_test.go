@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadersWithCommas(t *testing.T) {
+	headers := ExtraHeaders
+	defer func() { ExtraHeaders = headers }()
+	if err := findSetting("headers").set("Accept: text/html, application/xml;; X-Crawl: test"); err != nil {
+		t.Fatal(err)
+	}
+	req, err := newRequest("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Accept"); got != "text/html, application/xml" {
+		t.Errorf("Accept header %q", got)
+	}
+	if got := req.Header.Get("X-Crawl"); got != "test" {
+		t.Errorf("X-Crawl header %q", got)
+	}
+	if got := configValue([]interface{}{"Accept: text/html, application/xml", "X-Crawl: test"}, findSetting("headers").separator); got != "Accept: text/html, application/xml;;X-Crawl: test" {
+		t.Errorf("config file headers read as %q", got)
+	}
+}
+
+// A server that stops sending partway through the body is timed out
+func TestReadTimeoutStalledBody(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><body><p>"+strings.Repeat("penguins ", 1000))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	readTimeout, totalTimeout, attempts := ReadTimeout, TotalTimeout, RetryAttempts
+	defer func() { ReadTimeout, TotalTimeout, RetryAttempts = readTimeout, totalTimeout, attempts }()
+	ReadTimeout, TotalTimeout, RetryAttempts = 100*time.Millisecond, 0, 1
+
+	start := time.Now()
+	results := GetURL(server.URL)
+	if results.Err == nil || !strings.Contains(results.Err.Error(), "no data for") {
+		t.Errorf("got error %v, want a read timeout", results.Err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v to time out", elapsed)
+	}
+}
+
+// The client keeps as many idle connections to a host as there are workers, so
+// it is rebuilt when concurrency changes
+func TestClientRebuiltForConcurrency(t *testing.T) {
+	concurrency := Concurrency
+	defer func() { Concurrency = concurrency }()
+	Concurrency = 3
+	before := HTTPClient()
+	Concurrency = 7
+	if HTTPClient() == before || client.config.concurrency != 7 {
+		t.Errorf("client not rebuilt for concurrency 7")
+	}
+}
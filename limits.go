@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Per crawl limits.  A value of 0 means no limit.
+
+var MaxPages = 0
+var MaxBytes = 0
+var MaxDuration time.Duration
+var MaxHostPages = 0
+
+// Per page limits.  A value of 0 means no limit.
+
+var MaxBodySize = 10 * 1024 * 1024
+var MaxTokens = 100000
+var MaxTermLength = 64
+
+// Tracks the pages and bytes used by one crawl against the limits
+type crawlLimits struct {
+	maxPages, maxBytes, maxHostPages int
+	started, deadline                time.Time
+	elapsed                          time.Duration // before the crawl was resumed
+	pages, bytes                     int
+	hostPages                        map[string]int
+	hostLimited                      map[string]bool
+	stoppedBy                        string
+	mux                              sync.Mutex
+}
+
+func newCrawlLimits() *crawlLimits {
+	cl := &crawlLimits{maxPages: MaxPages, maxBytes: MaxBytes, maxHostPages: MaxHostPages, hostPages: make(map[string]int),
+		hostLimited: make(map[string]bool), started: time.Now()}
+	if MaxDuration > 0 {
+		cl.deadline = cl.started.Add(MaxDuration)
+	}
+	return cl
+}
+
+// What a crawl has used of its limits, saved in checkpoints so that a resumed
+// crawl carries on against the same limits
+type limitCounts struct {
+	Pages, Bytes    int
+	HostPages       map[string]int
+	HostLimitedURLs map[string]bool
+	Elapsed         time.Duration
+}
+
+func (cl *crawlLimits) Counts() limitCounts {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	counts := limitCounts{Pages: cl.pages, Bytes: cl.bytes, HostPages: make(map[string]int), HostLimitedURLs: make(map[string]bool)}
+	for host, n := range cl.hostPages {
+		counts.HostPages[host] = n
+	}
+	for u := range cl.hostLimited {
+		counts.HostLimitedURLs[u] = true
+	}
+	counts.Elapsed = cl.elapsed + time.Since(cl.started)
+	return counts
+}
+
+// Carry on from the counts of the crawl being resumed
+func (cl *crawlLimits) Restore(counts limitCounts) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	cl.pages, cl.bytes, cl.elapsed = counts.Pages, counts.Bytes, counts.Elapsed
+	for host, n := range counts.HostPages {
+		cl.hostPages[host] = n
+	}
+	for u := range counts.HostLimitedURLs {
+		cl.hostLimited[u] = true
+	}
+	if MaxDuration > 0 {
+		cl.deadline = cl.started.Add(MaxDuration - cl.elapsed)
+	}
+}
+
+// Reserve a fetch of url on host.  Returns false if the page should not be
+// fetched, either because the crawl has hit one of its limits or because host
+// has had its share of pages.  Pages are reserved before they are fetched and
+// bytes drawn as they are read, so pages in progress never take the crawl over
+// maxpages or maxbytes.
+func (cl *crawlLimits) Start(url, host string) bool {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	if cl.stoppedBy == "" {
+		switch {
+		case cl.maxPages > 0 && cl.pages >= cl.maxPages:
+			cl.stoppedBy = "maxpages"
+		case cl.maxBytes > 0 && cl.bytes >= cl.maxBytes:
+			cl.stoppedBy = "maxbytes"
+		case !cl.deadline.IsZero() && time.Now().After(cl.deadline):
+			cl.stoppedBy = "maxduration"
+		}
+	}
+	if cl.stoppedBy != "" {
+		return false
+	}
+	if cl.maxHostPages > 0 && cl.hostPages[host] >= cl.maxHostPages {
+		// counted once however many times it is linked to
+		cl.hostLimited[url] = true
+		return false
+	}
+	cl.pages++
+	cl.hostPages[host]++
+	return true
+}
+
+type crawlLimitsKey struct{}
+
+// A context for the crawl's fetches that is done when the crawl reaches
+// maxduration.  The bodies of the responses fetched in it draw on maxbytes as
+// they are read.
+func (cl *crawlLimits) Context() (context.Context, context.CancelFunc) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	ctx := context.WithValue(context.Background(), crawlLimitsKey{}, cl)
+	if cl.deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, cl.deadline)
+}
+
+// The limits of the crawl a request was made for, if any
+func requestLimits(req *http.Request) (*crawlLimits, bool) {
+	cl, ok := req.Context().Value(crawlLimitsKey{}).(*crawlLimits)
+	return cl, ok
+}
+
+// Give back a page reserved by Start that was not fetched after all
+func (cl *crawlLimits) Release(host string) {
+	cl.mux.Lock()
+	cl.pages--
+	cl.hostPages[host]--
+	cl.mux.Unlock()
+}
+
+// Draw up to n bytes from what is left under maxbytes
+func (cl *crawlLimits) takeBytes(n int) int {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	if cl.maxBytes > 0 && n > cl.maxBytes-cl.bytes {
+		n = max(cl.maxBytes-cl.bytes, 0)
+	}
+	cl.bytes += n
+	return n
+}
+
+// Stop the crawl on reaching limit, unless it already stopped
+func (cl *crawlLimits) stop(limit string) {
+	cl.mux.Lock()
+	if cl.stoppedBy == "" {
+		cl.stoppedBy = limit
+	}
+	cl.mux.Unlock()
+}
+
+// Give back bytes drawn by takeBytes that weren't read
+func (cl *crawlLimits) returnBytes(n int) {
+	cl.mux.Lock()
+	cl.bytes -= n
+	cl.mux.Unlock()
+}
+
+// The limit that stopped the crawl, or "" if it ran to completion
+func (cl *crawlLimits) Stopped() string {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	return cl.stoppedBy
+}
+
+// The number of URLs skipped because their host reached maxhostpages
+func (cl *crawlLimits) HostLimited() int {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	return len(cl.hostLimited)
+}
+
+// Counts the bytes read from a response body
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+// Reads the body of a response while its crawl has bytes left under maxbytes,
+// then reports EOF.  truncated is set if there was more to read.
+type byteBudgetReader struct {
+	r         io.Reader
+	limits    *crawlLimits
+	truncated bool
+}
+
+func (br *byteBudgetReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return br.r.Read(p)
+	}
+	allowed := br.limits.takeBytes(len(p))
+	if allowed == 0 {
+		if !br.truncated {
+			var probe [1]byte
+			if n, _ := br.r.Read(probe[:]); n > 0 {
+				br.truncated = true
+				br.limits.stop("maxbytes")
+			}
+		}
+		return 0, io.EOF
+	}
+	n, err := br.r.Read(p[:allowed])
+	br.limits.returnBytes(allowed - n)
+	return n, err
+}
+
+// Reads at most max bytes, if max is more than 0, then reports EOF.  truncated
+// is set if there was more to read.
+type sizeLimitReader struct {
+	r         io.Reader
+	max, read int
+	truncated bool
+}
+
+func (sl *sizeLimitReader) Read(p []byte) (int, error) {
+	if sl.max > 0 && sl.read >= sl.max {
+		if !sl.truncated {
+			var probe [1]byte
+			if n, _ := sl.r.Read(probe[:]); n > 0 {
+				sl.truncated = true
+			}
+		}
+		return 0, io.EOF
+	}
+	if sl.max > 0 && len(p) > sl.max-sl.read {
+		p = p[:sl.max-sl.read]
+	}
+	n, err := sl.r.Read(p)
+	sl.read += n
+	return n, err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Bodies read at the same time never draw more than maxbytes between them
+func TestByteBudgetConcurrentReads(t *testing.T) {
+	maxBytes := MaxBytes
+	defer func() { MaxBytes = maxBytes }()
+	MaxBytes = 10000
+	limits := newCrawlLimits()
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	total, truncated := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			br := &byteBudgetReader{r: strings.NewReader(strings.Repeat("x", 3000)), limits: limits}
+			n, _ := io.Copy(io.Discard, br)
+			mux.Lock()
+			total += int(n)
+			if br.truncated {
+				truncated++
+			}
+			mux.Unlock()
+		}()
+	}
+	wg.Wait()
+	if total != 10000 || truncated == 0 {
+		t.Errorf("read %v bytes with %v bodies truncated, want 10000 and some", total, truncated)
+	}
+}
+
+func TestMaxBytesTruncatesPagesInProgress(t *testing.T) {
+	maxBytes := MaxBytes
+	defer func() { MaxBytes = maxBytes }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			for i := 0; i < 5; i++ {
+				fmt.Fprintf(w, `<a href="/%v.html">%v</a>`, i, i)
+			}
+			return
+		}
+		fmt.Fprint(w, "<html><body><p>"+strings.Repeat("penguins ", 500)+"</p></body></html>")
+	}))
+	defer server.Close()
+
+	MaxBytes = 6000
+	visited, index, titles := newTestIndex()
+	results := Crawl(server.URL+"/", 1, 5, visited, index, titles)
+	if results.stoppedBy != "maxbytes" || results.truncated == 0 {
+		t.Errorf("stopped by %q with %v pages truncated, want maxbytes and some", results.stoppedBy, results.truncated)
+	}
+}
+
+// A URL skipped by maxhostpages counts once however often it is linked to
+func TestHostLimitedCountedOnce(t *testing.T) {
+	maxHostPages, policy := MaxHostPages, DomainPolicy
+	defer func() { MaxHostPages, DomainPolicy = maxHostPages, policy }()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<a href="%v/a.html">a</a> <a href="%v/b.html">b</a> <a href="/x.html">x</a>`, other, other)
+		case "/a.html":
+			fmt.Fprintf(w, `<a href="%v/x.html">x</a>`, server.URL)
+		default:
+			fmt.Fprint(w, "<p>page</p>")
+		}
+	}))
+	defer server.Close()
+
+	MaxHostPages, DomainPolicy = 1, "any"
+	visited, index, titles := newTestIndex()
+	results := Crawl(server.URL+"/", 2, 1, visited, index, titles)
+	if results.uniquePages != 2 || results.hostLimited != 2 {
+		t.Errorf("crawled %v pages with %v host limited, want 2 and 2 (b.html and x.html)", results.uniquePages, results.hostLimited)
+	}
+}
+
+// A page is flagged truncated when it reaches a page limit, whether or not it
+// has a content handler
+func TestPageLimitsFlagTruncation(t *testing.T) {
+	maxBody, maxTokens := MaxBodySize, MaxTokens
+	defer func() { MaxBodySize, MaxTokens = maxBody, maxTokens }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, strings.Repeat("x", 2000))
+		case "/doc.pdf":
+			// only the header, the rest of the file is cut off
+			w.Header().Set("Content-Type", "application/pdf")
+			fmt.Fprint(w, "%PDF-1.4\n"+strings.Repeat("x", 2000))
+		case "/exact.html":
+			fmt.Fprint(w, "<html><body><p>one two three</p></body></html>")
+		default:
+			fmt.Fprint(w, "<html><body><p>one two</p></body></html>")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path, limit       string
+		maxBody, maxTerms int
+		want              string
+	}{
+		{"/data.bin", "maxbodysize", 1000, 0, "maxbodysize"},
+		{"/data.bin", "none", 0, 0, ""},
+		{"/doc.pdf", "maxbodysize", 1000, 0, "maxbodysize"},
+		{"/exact.html", "maxtokens", 0, 3, "maxtokens"},
+		{"/short.html", "maxtokens", 0, 3, ""},
+	}
+	for _, test := range tests {
+		MaxBodySize, MaxTokens = test.maxBody, test.maxTerms
+		results := GetURL(server.URL + test.path)
+		if results.Err != nil {
+			t.Fatalf("GetURL(%v) failed: %v", test.path, results.Err)
+		}
+		if results.Truncated != test.want {
+			t.Errorf("%v with %v truncated %q, want %q", test.path, test.limit, results.Truncated, test.want)
+		}
+	}
+
+	// a whole pdf that doesn't parse is still an error
+	MaxBodySize = 0
+	if results := GetURL(server.URL + "/doc.pdf"); results.Err == nil || results.Truncated != "" {
+		t.Errorf("unreadable pdf got error %v truncated %q, want an error", results.Err, results.Truncated)
+	}
+}
+
+// Fetches cut short by maxduration stop the crawl rather than count as errors
+func TestMaxDurationStopsFetchesInProgress(t *testing.T) {
+	maxDuration := MaxDuration
+	defer func() { MaxDuration = maxDuration }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/slow.html">slow</a>`)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	MaxDuration = 300 * time.Millisecond
+	visited, index, titles := newTestIndex()
+	results := Crawl(server.URL+"/", 1, 2, visited, index, titles)
+	if results.stoppedBy != "maxduration" || len(results.errors) != 0 {
+		t.Errorf("stopped by %q with errors %v, want maxduration and none", results.stoppedBy, results.errors)
+	}
+}
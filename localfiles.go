@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local files.  file:// urls are fetched from the local filesystem by the same
+// HTTP client as web pages, so offline HTML exports and docs folders are parsed
+// by the same content handlers and their relative links resolve between files.
+// A directory is served as its index.html, or else as a listing of links to its
+// contents marked noindex, so crawling a directory crawls the files in it.
+// file:// links are only followed from local files, never from web pages.
+//
+// The indexdir command walks a directory tree instead of following links, and
+// indexes every file with a content handler for its extension.  Both apply
+// these filters, which like the scope rules are checked before a file is queued:
+//
+//	fileinclude	if set, only files matching one of these globs are indexed
+//	fileexclude	files and directories matching any of these are skipped
+//	maxfilesize	files larger than this many bytes are skipped, 0 for no limit
+//
+// A pattern with no / is matched against the file name, one starting with / the
+// whole path, and any other the same number of trailing path segments, e.g.
+// drafts/* matches every file directly in a directory named drafts.
+
+// The files served for a directory, in order
+var fileDirectoryIndexes = []string{"index.html", "index.htm"}
+
+var FileInclude []string
+var FileExclude = []string{".*"}
+var MaxFileSize = 0
+
+// Whether a local file path matches a fileinclude or fileexclude pattern
+func matchFilePattern(pattern, name string) bool {
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(pattern, "/") {
+		segments := strings.Split(name, "/")
+		if n := strings.Count(pattern, "/") + 1; n < len(segments) {
+			name = strings.Join(segments[len(segments)-n:], "/")
+		}
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// The filter that skips a local file or directory, or "" if it is indexed.
+// fileinclude and maxfilesize only apply to files.
+func fileFilter(name string, info fs.FileInfo) string {
+	for _, pattern := range FileExclude {
+		if matchFilePattern(pattern, name) {
+			return "fileexclude " + pattern
+		}
+	}
+	if info.IsDir() {
+		return ""
+	}
+	if len(FileInclude) > 0 {
+		included := false
+		for _, pattern := range FileInclude {
+			if matchFilePattern(pattern, name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return "fileinclude"
+		}
+	}
+	if MaxFileSize > 0 && info.Size() > int64(MaxFileSize) {
+		return "maxfilesize"
+	}
+	return ""
+}
+
+// The filter that skips the file a file:// url names.  Missing files are left
+// to the fetch to report.
+func localFileFilter(u *url.URL) string {
+	info, err := os.Stat(filepath.FromSlash(u.Path))
+	if err != nil {
+		return ""
+	}
+	return fileFilter(u.Path, info)
+}
+
+// The file:// url of an absolute local path
+func fileURL(name string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(name)}).String()
+}
+
+// Serves file:// urls from the local filesystem
+type fileTransport struct{}
+
+func fileResponse(req *http.Request, code int) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%v %v", code, http.StatusText(code)),
+		StatusCode: code,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+func (fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "" && req.URL.Host != "localhost" {
+		return nil, fmt.Errorf("file urls on other hosts, such as %v, are not supported", req.URL.Host)
+	}
+	name := filepath.FromSlash(req.URL.Path)
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return fileResponse(req, http.StatusNotFound), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return serveFile(req, name, info)
+	}
+	if !strings.HasSuffix(req.URL.Path, "/") {
+		// so that relative links from the directory resolve inside it
+		resp := fileResponse(req, http.StatusMovedPermanently)
+		resp.Header.Set("Location", (&url.URL{Path: req.URL.Path + "/"}).EscapedPath())
+		return resp, nil
+	}
+	for _, index := range fileDirectoryIndexes {
+		indexName := filepath.Join(name, index)
+		if indexInfo, err := os.Stat(indexName); err == nil && !indexInfo.IsDir() {
+			return serveFile(req, indexName, indexInfo)
+		}
+	}
+	return serveListing(req, name)
+}
+
+func serveFile(req *http.Request, name string, info fs.FileInfo) (*http.Response, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	resp := fileResponse(req, http.StatusOK)
+	resp.Body = f
+	resp.ContentLength = info.Size()
+	// left empty, the content type is sniffed from the file
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	resp.Header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	return resp, nil
+}
+
+// A page of links to the contents of a directory, which is crawled but not indexed
+func serveListing(req *http.Request, name string) (*http.Response, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<html><head><title>%v</title></head><body>\n", html.EscapeString(req.URL.Path))
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		href := (&url.URL{Path: entryName}).EscapedPath()
+		fmt.Fprintf(&b, "<a href=\"./%v\">%v</a><br>\n", html.EscapeString(href), html.EscapeString(entryName))
+	}
+	b.WriteString("</body></html>\n")
+	resp := fileResponse(req, http.StatusOK)
+	resp.Body = io.NopCloser(strings.NewReader(b.String()))
+	resp.ContentLength = int64(b.Len())
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set("X-Robots-Tag", "noindex")
+	return resp, nil
+}
+
+// Index the files under dir that pass the file filters and have a content
+// handler, following their links as an index crawl would
+func IndexDir(dir string, visited *VisitedMap, index *Index, titles *URLtitles) {
+	root, err := filepath.Abs(dir)
+	if err == nil {
+		var info fs.FileInfo
+		if info, err = os.Stat(root); err == nil && !info.IsDir() {
+			err = fmt.Errorf("not a directory")
+		}
+	}
+	if err != nil {
+		fmt.Printf("Unable to index directory %v: %v\n", dir, err)
+		return
+	}
+
+	var requests []crawlRequest
+	skipped := 0
+	filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read %v: %v\n", name, err)
+			return nil
+		}
+		if name == root {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if fileFilter(name, info) != "" {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			skipped++
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		u := fileURL(name)
+		parsed, _ := url.Parse(u)
+		if _, ok := handlerFor(contentType, parsed); ok {
+			requests = append(requests, crawlRequest{u, 0, 0})
+		}
+		return nil
+	})
+
+	rooturl := fileURL(root + string(filepath.Separator))
+	progressf("Initiating index of %v files in %v, %v skipped by the file filters\n", len(requests), root, skipped)
+	results := crawlFrom(rooturl, MaxDepth, Concurrency, requests, nil, CheckpointDir, visited, index, titles)
+	lastCrawlErrors = results.errors
+	renderCrawlSummary(rooturl, results)
+}
@@ -2,17 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"regexp"
 	"sync"
-	"strconv"
 	"strings"
 	"sort"
+	"time"
+	"unicode/utf8"
 	"net/url"
-	"io"
 	"net/http"
-	"log"
+	"io"
 	"golang.org/x/net/html"
 	
 )
@@ -67,25 +69,59 @@ func (gi *Index) Reset(){
 	}
 }
 
-// Map the URL's to their titles
+// Map the URL's to their titles, and what else was learned fetching them
 // Used when diplaying results
 
+type PageInfo struct {
+	Title		string
+	Status		int
+	ContentType	string
+	FinalURL	string
+	Charset		string
+	Truncated	string	// the limit that cut the page short, if any
+	Fingerprint	uint64	// SimHash of the page's terms, see duplicates.go
+	DuplicateOf	string	// the indexed page this one is a near-duplicate of, if any
+	Description	string	// from <meta name="description">, shown as the snippet in search results
+	Meta		map[string][]string	// OpenGraph, JSON-LD and microdata, see metadata.go
+}
+
 type URLtitles struct {
-	titles map[string]string
-	mux    sync.Mutex
+	titles map[string]PageInfo
+	// the pages with each 16 bits of a fingerprint, for finding near-duplicates
+	bands [fingerprintBands]map[uint16][]string
+	mux   sync.Mutex
+}
+
+func newURLtitles() *URLtitles {
+	return &URLtitles{titles: make(map[string]PageInfo)}
 }
 
 func (ut *URLtitles) Add(url, title string) {
 	ut.mux.Lock()
-	ut.titles[url] = title
+	info := ut.titles[url]
+	info.Title = title
+	ut.titles[url] = info
+	ut.mux.Unlock()
+}
+
+func (ut *URLtitles) AddPage(url string, info PageInfo) {
+	ut.mux.Lock()
+	ut.setPage(url, info)
 	ut.mux.Unlock()
 }
 
 func (ut *URLtitles) Get(url string) (string, bool) {
 	ut.mux.Lock()
 	defer ut.mux.Unlock()
-	title, ok := ut.titles[url]
-	return title, ok
+	info, ok := ut.titles[url]
+	return info.Title, ok
+}
+
+func (ut *URLtitles) GetPage(url string) (PageInfo, bool) {
+	ut.mux.Lock()
+	defer ut.mux.Unlock()
+	info, ok := ut.titles[url]
+	return info, ok
 }
 
 func (ut *URLtitles) Reset(){
@@ -94,6 +130,7 @@ func (ut *URLtitles) Reset(){
 	for key, _ := range ut.titles {
 		delete (ut.titles, key)
 	}
+	ut.bands = [fingerprintBands]map[uint16][]string{}
 }
 
 
@@ -117,6 +154,32 @@ func (vm *VisitedMap) Value(url string) (int, bool) {
 	return depth, ok
 }
 
+// Check whether url should be crawled at this depth, and if so mark it visited.
+// A url already visited at a greater depth is crawled again, since there may be
+// more links to follow, but should not be indexed again.
+func (vm *VisitedMap) CheckAndVisit(url string, depth int) (crawl, doIndexing bool) {
+	vm.mux.Lock()
+	defer vm.mux.Unlock()
+	priorDepth, ok := vm.v[url]
+	if ok && depth >= priorDepth {
+		return false, false
+	}
+	vm.v[url] = depth
+	return true, !ok
+}
+
+// Undo CheckAndVisit for a url that was not crawled after all, putting back the
+// depth it was visited at before, if any
+func (vm *VisitedMap) Unvisit(url string, priorDepth int, wasVisited bool) {
+	vm.mux.Lock()
+	defer vm.mux.Unlock()
+	if wasVisited {
+		vm.v[url] = priorDepth
+	} else {
+		delete(vm.v, url)
+	}
+}
+
 func (vm *VisitedMap) Reset(){
 	vm.mux.Lock()
 	defer vm.mux.Unlock()
@@ -128,33 +191,194 @@ func (vm *VisitedMap) Reset(){
 type UrlParseResults struct {
 	URL, Title 	string
 	EmbeddedURL	map[string]int
+	LinkSources	map[string][]string	// the kinds of tag each embedded url was found in
 	Index		map[string]int
+	Err		error
+	Bytes		int
+	Attempts	int
+	Status		int
+	ContentType	string
+	FinalURL	string
+	Charset		string
+	Truncated	string
+	NoIndex		bool
+	NoFollow	bool
+	Canonical	string
+	Fingerprint	uint64
+	Description	string
+	Meta		map[string][]string
+	Handled		bool	// whether a content handler parsed the page
+}
+
+// Whether the results came from a page that was fetched and parsed by one of
+// the content handlers
+func (r UrlParseResults) Parsed() bool {
+	return r.Err == nil && r.Handled
+}
+
+func (r UrlParseResults) PageInfo() PageInfo {
+	return PageInfo{r.Title, r.Status, r.ContentType, r.FinalURL, r.Charset, r.Truncated, r.Fingerprint, "", r.Description, r.Meta}
 }
 
 // Used in cleaning up the content on a page
 var Punctuation []string
-var checkRelativeLink = regexp.MustCompile(`(.+?)#`)
 
 var CaseSensitive = false
 var IndexAnchorTitles = true
+var IndexAltText = true
+var IndexDescription = true
 
-// Retrieve and parse the given URL
+// Retrieve and parse the given URL, retrying failures that may be temporary
 func GetURL(url string) UrlParseResults {
+	return GetURLContext(context.Background(), url)
+}
+
+// GetURL within ctx, which cancels the fetch and any wait to retry it
+func GetURLContext(ctx context.Context, url string) UrlParseResults {
+	for attempt := 1; ; attempt++ {
+		results := getURLOnce(ctx, url)
+		results.Attempts = attempt
+		if results.Err == nil || attempt >= RetryAttempts {
+			return results
+		}
+		wait, retryable := retryDelay(results.Err, attempt)
+		if !retryable || !retryWait(ctx, wait) {
+			return results
+		}
+	}
+}
+
+func getURLOnce(ctx context.Context, url string) UrlParseResults {
 
 	
 	pageTitle := url
-	inBody := false
-	
-	embeddedURL := make(map[string]int)
-	thisIndex := make(map[string]int)
 
-	resp, err := http.Get(url)
+	resp, err := httpGet(ctx, url)
 	if err != nil {
-		fmt.Printf("GetURL: Error on getting %v: %v \n", url, err)
-		return UrlParseResults{url, pageTitle, nil, nil}
+		return UrlParseResults{URL: url, Title: pageTitle, Err: err}
 	}
 	defer resp.Body.Close()
-	tokenizer := html.NewTokenizer(resp.Body)
+	return parseResponse(url, resp)
+}
+
+// Parse the response to a request for url with the content handler for its
+// type.  This is also used for responses read back from WARC files.
+func parseResponse(url string, resp *http.Response) UrlParseResults {
+	
+	pageTitle := url
+	
+	// links on the page are relative to where any redirects ended up
+	base := resp.Request.URL.String()
+	body := &countingReader{r: resp.Body}
+	// in a crawl the body draws on maxbytes as it is read
+	var budget *byteBudgetReader
+	if limits, ok := requestLimits(resp.Request); ok {
+		budget = &byteBudgetReader{r: resp.Body, limits: limits}
+		body.r = budget
+	}
+	results := UrlParseResults{URL: url, Title: pageTitle, Status: resp.StatusCode, FinalURL: base}
+	
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		results.Err = newStatusError(resp)
+		return results
+	}
+	
+	// robots directives from the headers apply to any kind of page
+	var robots robotsDirectives
+	for _, value := range resp.Header.Values("X-Robots-Tag") {
+		robots.Parse(value)
+	}
+	results.NoIndex = robots.noIndex
+	results.NoFollow = robots.noFollow
+	
+	// Sniff the content type if the server didn't say
+	limitedBody := &sizeLimitReader{r: body, max: MaxBodySize}
+	peek := bufio.NewReader(limitedBody)
+	results.ContentType = resp.Header.Get("Content-Type")
+	if results.ContentType == "" {
+		start, _ := peek.Peek(512)
+		results.ContentType = http.DetectContentType(start)
+	}
+	// the limit that cut the body short, if any
+	bodyTruncated := func() string {
+		switch {
+		case budget != nil && budget.truncated:
+			return "maxbytes"
+		case limitedBody.truncated:
+			return "maxbodysize"
+		}
+		return ""
+	}
+	handler, ok := handlerFor(results.ContentType, resp.Request.URL)
+	if !ok {
+		// recorded but not parsed
+		io.Copy(io.Discard, peek)
+		results.Bytes = body.n
+		results.Truncated = bodyTruncated()
+		return results
+	}
+	
+	doc := newDocument(peek, results.ContentType, resp.Request.URL)
+	err := handler(doc, &results)
+	results.Bytes = body.n
+	if err != nil {
+		// a file cut short may not parse at all, e.g. a pdf keeps its index at
+		// the end, so it is reported as truncated rather than as an error
+		if truncated := bodyTruncated(); truncated != "" {
+			results.Truncated = truncated
+			return results
+		}
+		results.Err = err
+		return results
+	}
+	results.Handled = true
+	if doc.truncated {
+		results.Truncated = "maxtokens"
+	}
+	if truncated := bodyTruncated(); truncated != "" {
+		results.Truncated = truncated
+	}
+	if !results.NoFollow {
+		results.EmbeddedURL = doc.links
+		results.LinkSources = doc.linkSources
+	}
+	if !results.NoIndex {
+		results.Index = doc.terms
+		results.Fingerprint = simhash(doc.terms)
+	}
+	return results
+}
+
+// The content handler for HTML pages: index the text of the body and collect the
+// links, title, robots directives, description and metadata
+func parseHTML(doc *document, results *UrlParseResults) error {
+	pageTitle := results.Title
+	inBody := false
+	base := doc.base.String()
+	
+	// Transcode to UTF-8 before tokenizing
+	tokenizer := html.NewTokenizer(doc.utf8Body(results))
+	
+	var robots robotsDirectives
+	addLink := doc.AddLink
+	indexText := doc.IndexText
+	metadata := make(pageMetadata)
+	microdata := newMicrodataParser(metadata)
+	
+	// With set extract main, text in the body is held back until the main
+	// content can be picked out at the end of the page
+	var extractor *contentExtractor
+	if ExtractMode == "main" {
+		extractor = newContentExtractor()
+	}
+	indexBodyText := func(s string) {
+		if extractor != nil {
+			extractor.Text(s)
+		} else {
+			indexText(s)
+		}
+	}
+	
 	for {
 		tokenType := tokenizer.Next()
 
@@ -164,18 +388,23 @@ func GetURL(url string) UrlParseResults {
 				//end of the file, break out of the loop
 				break
 			}
-
-			log.Fatalf("error tokenizing HTML: %v", tokenizer.Err())
+			return err
 		}
 
 		token := tokenizer.Token()
 		data := strings.TrimSpace(token.Data)
 		switch tokenType {
 				
-		case html.StartTagToken:
+		case html.StartTagToken, html.SelfClosingTagToken:
+			// the contents of scripts and style sheets are read below
+			selfClosing := tokenType == html.SelfClosingTagToken || data == "script" || data == "style"
+			microdata.StartTag(token, selfClosing)
+			if extractor != nil {
+				extractor.StartTag(token, selfClosing)
+			}
 		
 			switch data {
-			case "a": 
+			case "a", "area": 
 				var newURL, title string
 				noFollow := false
 				
@@ -184,39 +413,87 @@ func GetURL(url string) UrlParseResults {
 				    	if strings.HasPrefix(attr.Val, "#") {
 				    		noFollow = true
 					}
-				    
-				        if strings.HasPrefix(attr.Val, "http:") || strings.HasPrefix(attr.Val, "https:") {
-				        	newURL = attr.Val
-				        } else if strings.Contains(attr.Val, ":") {
-				        	noFollow = true
-				        } else {
-				    	    	newURL = url + "/" + attr.Val
-				    	    	if strings.HasPrefix (attr.Val, "/") {
-				    	    		newURL = url + attr.Val
-				    	    	}
-					}	
+				    	newURL = attr.Val
 				    }
 				    
-				    if attr.Key == "rel" && attr.Val == "nofollow" {
+				    if attr.Key == "rel" && hasRel(attr.Val, "nofollow") {
 				    	noFollow = true
 				    }
 				    
 				    if attr.Key == "title" {
 				    	title = attr.Val
 				    	if IndexAnchorTitles {
-				    		addToURLIndex(title, thisIndex)
+				    		indexBodyText(title)
 				    	}
 				    }
 				} // done processing attributes
 				
-				// last check in case we ended up with a relative link
-				isRelativeLink := checkRelativeLink.FindStringSubmatch(newURL)
-							
-				if isRelativeLink != nil {
-					newURL = isRelativeLink[1]
+				if !noFollow && newURL != "" {
+					addLink(newURL, data)
+				}
+			
+			case "img":
+				// the alt text is often the only text on image heavy pages
+				for _, attr := range token.Attr {
+					if attr.Key == "alt" && IndexAltText {
+						indexBodyText(attr.Val)
+					}
+				}
+			
+			case "frame", "iframe":
+				for _, attr := range token.Attr {
+					if attr.Key == "src" && attr.Val != "" {
+						addLink(attr.Val, data)
+					}
+				}
+			
+			case "meta":
+				var name, property, httpEquiv, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "name":
+						name = attr.Val
+					case "property":
+						property = attr.Val
+					case "http-equiv":
+						httpEquiv = attr.Val
+					case "content":
+						content = attr.Val
+					}
+				}
+				if isRobotsMeta(name) {
+					robots.Parse(content)
+				}
+				if property != "" {
+					metadata.OpenGraph(property, content)
+				}
+				if strings.EqualFold(name, "description") && results.Description == "" {
+					results.Description = strings.Join(strings.Fields(content), " ")
+					if IndexDescription {
+						indexText(content)
+					}
+				}
+				if strings.EqualFold(httpEquiv, "refresh") {
+					if target := refreshURL(content); target != "" {
+						addLink(target, "refresh")
+					}
+				}
+			
+			case "link":
+				var rel, href string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "rel":
+						rel = attr.Val
+					case "href":
+						href = attr.Val
+					}
 				}
-				if !noFollow {
-					embeddedURL[newURL]++
+				if hasRel(rel, "canonical") && results.Canonical == "" {
+					results.Canonical = resolveCanonical(base, href)
+				}
+				if (hasRel(rel, "next") || hasRel(rel, "prev")) && href != "" {
+					addLink(href, "link")
 				}
 			
 			case "title":  
@@ -228,39 +505,91 @@ func GetURL(url string) UrlParseResults {
 				inBody = true
 				
 			case "style":
-				// skip style sheets
+				if tokenType == html.SelfClosingTagToken {
+					// nothing to skip, and the tokenizer mustn't read on as if there were
+					tokenizer.NextIsNotRawText()
+					break
+				}
+				// skip style sheets, stopping at the end of a truncated page
 				for {
-					tokenizer.Next()
+					if tokenizer.Next() == html.ErrorToken {
+						break
+					}
 					token := tokenizer.Token()
 					if strings.TrimSpace(token.Data) == "style" {
 						break
 					}
 				}
 			case "script":
-				// skip scripts
+				if tokenType == html.SelfClosingTagToken {
+					// <script src="..."/> has no contents to skip
+					tokenizer.NextIsNotRawText()
+					break
+				}
+				// skip scripts, stopping at the end of a truncated page, but
+				// keep JSON-LD blocks for the page metadata
+				jsonLD := false
+				for _, attr := range token.Attr {
+					if attr.Key == "type" && strings.EqualFold(strings.TrimSpace(attr.Val), "application/ld+json") {
+						jsonLD = true
+					}
+				}
+				var script strings.Builder
 				for {
-					tokenizer.Next()
+					tokenType := tokenizer.Next()
+					if tokenType == html.ErrorToken {
+						break
+					}
 					token := tokenizer.Token()
+					if tokenType == html.TextToken && jsonLD {
+						script.WriteString(token.Data)
+						continue
+					}
 					if strings.TrimSpace(token.Data) == "script" {
 						break
 					}
 				}
+				if jsonLD {
+					metadata.JSONLD(script.String())
+				}
+			}
+
+		case html.EndTagToken:
+			microdata.EndTag(data)
+			if extractor != nil {
+				extractor.EndTag(data)
 			}
 
 		case html.TextToken:
+			microdata.Text(token.Data)
 			if inBody && len(data) > 0 {
 				// fmt.Printf("Text - need to index %v \n", token.Data)
-				addToURLIndex(token.Data, thisIndex)
+				indexBodyText(token.Data)
 			}
 				
 		}
 	}	
-	return UrlParseResults{url, pageTitle, embeddedURL, thisIndex}
+	if extractor != nil {
+		for _, text := range extractor.MainText() {
+			indexText(text)
+		}
+	}
+	results.Title = pageTitle
+	if len(metadata) > 0 {
+		results.Meta = metadata
+	}
+	// the headers may already have said noindex or nofollow
+	results.NoIndex = results.NoIndex || robots.noIndex
+	results.NoFollow = results.NoFollow || robots.noFollow
+	return nil
 }
 
-// Add this text to the index for this page
-func addToURLIndex (s string, m map[string]int) {
+// Add this text to the index for this page, at most limit terms if limit is
+// more than 0.  Terms longer than MaxTermLength are skipped.  Returns the number
+// of terms added.
+func addToURLIndex (s string, m map[string]int, limit int) int {
 	
+	added := 0	
 	for _, p := range Punctuation {
 		s = strings.Replace(s, p, "", -1)
 	}
@@ -273,12 +602,19 @@ func addToURLIndex (s string, m map[string]int) {
 	for _, token := range tokens {
 	        token = strings.TrimSpace(token)
 	        token = strings.TrimSuffix(token, ":")
+		if MaxTermLength > 0 && utf8.RuneCountInString(token) > MaxTermLength {
+			continue
+		}
 		if len(token) > 0 {
+			if limit > 0 && added >= limit {
+				break
+			}
 			m[token]++
+			added++
 		}
 	}
 
-	return
+	return added
 }
 
 // Load up the Pnctuation slice.  Used to get rid of extraneous characters that affect the indexing
@@ -297,145 +633,288 @@ type crawlResult struct {
 
 type crawlSummary struct {
 	uniquePages, uniqueTerms int
+	errors []crawlError
+	rejected []scopeReject
+	traps []trapReport
+	stoppedBy string
+	hostLimited int
+	notIndexed int
+	truncated int
+	duplicates int
 }
 
 type crawlRequest struct {
 	url 	string
 	depth 	int
+	priority	float64
 }
 
-func CrawlURL (url string, token chan struct{})  UrlParseResults{
-	token <- struct{}{}
-	theseResults := GetURL(url)
-	<-token
-	return theseResults
+func Crawl (rooturl string, maxdepth, concurrency int, visited *VisitedMap, index *Index, titles *URLtitles)  crawlSummary {
+	rootRequest := crawlRequest{rooturl, 0, 0}
+	return crawlFrom(rooturl, maxdepth, concurrency, []crawlRequest{rootRequest}, nil, CheckpointDir, visited, index, titles)
 }
 
-var crawlwg sync.WaitGroup 
-func Crawl (rooturl string, maxdepth, concurrency int, visited VisitedMap, index Index, titles URLtitles)  crawlSummary {
+// Crawl starting from the given requests, carrying on from the counts in resumed
+// if it is set.  This is used both for new crawls and to resume one from a
+// checkpoint.  If checkpointDir is set the crawl is checkpointed there every
+// CheckpointInterval and when it ends.
+func crawlFrom (rooturl string, maxdepth, concurrency int, requests []crawlRequest, resumed *checkpoint, checkpointDir string, visited *VisitedMap, index *Index, titles *URLtitles)  crawlSummary {
 	
 	parsedrooturl, _ := url.Parse(rooturl)
-	rootHost := strings.TrimPrefix(parsedrooturl.Host, "www.")
-	
-	// need to make this a buffered channel so we can have multiple request sets 
-	requestlist := make(chan []crawlRequest, 1000)
-	tokens := make( chan struct{}, concurrency)
+	scope := newCrawlScope(parsedrooturl, ScopeRules)
+	traps := newCrawlTraps()
+	limits := newCrawlLimits()
+	work := newFrontier()
 	
-	uniquePages := 0
-	uniqueTerms := 0
-	rootRequest := crawlRequest{rooturl, 0}
-	go func() {requestlist <- []crawlRequest{rootRequest} }()
-	var n int
-	n++
+	var start crawlSummary
+	if resumed != nil {
+		start = resumed.summary()
+		scope.Restore(resumed.Scope)
+		traps.Restore(resumed.Traps)
+		limits.Restore(resumed.Limits)
+	}
+	// ends the fetches, and waits to retry them, at maxduration
+	ctx, cancel := limits.Context()
+	defer cancel()
+	uniquePages := start.uniquePages
+	uniqueTerms := start.uniqueTerms
+	crawlErrors := start.errors
+	notIndexed := start.notIndexed
+	duplicates := start.duplicates
+	truncated := start.truncated
+	var summaryMux sync.Mutex
+	resetFixtureFetches()
+	defer startWARC()()
 	
-	for ; n > 0; n-- {
+	process := func(request crawlRequest) {
+		parsedRequestURL, _ := url.Parse(request.url)
+		cleanRequestURL := normalizeURL(request.url)
+		key := visitedKey(request.url)
+
+		// See if we need to visit this URL
+		priorDepth, wasVisited := visited.Value(key)
+		if wasVisited && request.depth >= priorDepth {
+			return
+		}
+		host := parsedRequestURL.Hostname()
+		if !limits.Start(key, host) {
+			if limits.Stopped() != "" {
+				// kept for the final checkpoint
+				work.Push(request)
+				work.Close()
+			}
+			return
+		}
+		crawl, doIndexing := visited.CheckAndVisit(key, request.depth)
+		if !crawl {
+			// another worker got here first
+			limits.Release(host)
+			return
+		}
 		
-		fmt.Printf(".")
-		requests := <-requestlist
+		theseResults := GetURLContext(ctx, request.url)
+		if theseResults.Err != nil && ctx.Err() != nil {
+			// cut short by maxduration, which is not an error in the page, so
+			// it goes back on the frontier to be fetched when the crawl resumes
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				limits.stop("maxduration")
+			}
+			limits.Release(host)
+			visited.Unvisit(key, priorDepth, wasVisited)
+			work.Push(request)
+			work.Close()
+			return
+		}
+		summaryMux.Lock()
+		uniquePages++
+		if uniquePages % 10 == 0 {
+			progressf(".")
+		}
+		if theseResults.Err != nil {
+			crawlErrors = append(crawlErrors, crawlError{theseResults.URL, theseResults.Err.Error(), theseResults.Attempts})
+		} else if !theseResults.Parsed() || theseResults.NoIndex {
+			notIndexed++
+		}
+		if theseResults.Truncated != "" {
+			truncated++
+		}
+		summaryMux.Unlock()
 		
-		// wait for all these requests to process 
-		// to make sure all work is done before returning results
-		crawlwg.Add(len(requests))
-		for _, request := range requests {
-			
-			// Clean up the requested url a little
-			parsedRequestURL, _ := url.Parse(request.url)
-			urlScheme := parsedRequestURL.Scheme + "://"
-			cleanRequestURL := strings.TrimPrefix(request.url, urlScheme)
-			cleanRequestURL = strings.TrimPrefix(cleanRequestURL, "www.")
-			cleanRequestURL = strings.TrimSuffix(cleanRequestURL, "/")
-
-			
-			// See if we need to visit this URL.  Don't include the scheme in the check
-			
-			doIndexing := true
-			priorDepth, ok := visited.Value(cleanRequestURL)
-			
-			// check to see if the url has already been scanned.  If it had been scanned a deeper level
-			// rescan it but don's add to the index any terms. This gets into delete / deep delete issues  
-			// we want to rescan in case there are more links that we should scan
-			if ok && request.depth < priorDepth {
-				ok = false
-				doIndexing = false
-				// fmt.Printf("Crawl: rescanning %v prior depth: %v request depth %v\n", cleanRequestURL, priorDepth, request.depth)
+		unique, duplicate := recordPage(cleanRequestURL, request.depth, doIndexing, theseResults, visited, index, titles)
+		summaryMux.Lock()
+		uniqueTerms += unique
+		if duplicate {
+			duplicates++
+		}
+		summaryMux.Unlock()
+		
+		if request.depth < maxdepth {
+			// queued in a fixed order, so a replayed crawl takes the same path
+			var newurls []string
+			for newurl := range theseResults.EmbeddedURL {
+				newurls = append(newurls, newurl)
 			}
-			
-			if !ok {
-				visited.Visit(cleanRequestURL, request.depth)
-				if request.depth < maxdepth {
-					n++
+			sort.Strings(newurls)
+			for _, newurl := range newurls {
+				// checked in the normalized form, but fetched as linked
+				parsednewurl, err := url.Parse(normalizeURL(newurl))
+				if err != nil || (parsednewurl.Host == "" && parsednewurl.Scheme != "file") {
+					continue
+				}
+				if scope.AllowSources(parsednewurl, theseResults.LinkSources[newurl]) && scope.Allow(parsednewurl) && traps.Allow(parsednewurl) {
+					work.Push(crawlRequest{newurl, request.depth + 1, 0})
 				}
-				uniquePages++
-				go func(request crawlRequest, doIndexing bool, token chan struct{}) {
-					defer crawlwg.Done()
-					theseResults := CrawlURL(request.url, token)
-					if doIndexing {
-						_, unique := index.Add(theseResults.URL, theseResults.Index) 
-						uniqueTerms += unique
-						titles.Add(theseResults.URL, theseResults.Title)
-					}
-					
-					if request.depth < maxdepth {
-						newrequestlist := []crawlRequest{}
-						for newurl, _ := range theseResults.EmbeddedURL {
-							parsednewurl, err := url.Parse(newurl)
-							if parsednewurl.Host == "" || err != nil {
-								continue
-							}
-							newHost := strings.TrimPrefix(parsednewurl.Host, "www.")
-							if newHost == rootHost || CrawlForeign {
-								newrequest := crawlRequest{newurl, request.depth + 1}
-								newrequestlist = append(newrequestlist, newrequest)
-							}
-						} // end going through the embedded urls
-
-						if len(newrequestlist) > 0 {
-							requestlist <- newrequestlist
-						} else {
-							n--
-						}
-					} // end adding more work
-				}(request, doIndexing, tokens)
-			} else {
-				// not going to do this url, so mark it complete
-				crawlwg.Done()
 			}
-		} // end going through this set of requests
-		// wait for each request to complete
-		crawlwg.Wait()
-		
+		}
+	}
+	
+	for _, request := range requests {
+		work.Push(request)
+	}
+	
+	// called with the workers paused or finished
+	saveCheckpoint := func() {
+		summaryMux.Lock()
+		saved := crawlSummary{uniquePages: uniquePages, uniqueTerms: uniqueTerms, errors: crawlErrors, notIndexed: notIndexed, truncated: truncated, duplicates: duplicates}
+		summaryMux.Unlock()
+		cp := newCheckpoint(rooturl, maxdepth, work.Snapshot(), saved, visited, index, titles)
+		cp.Scope, cp.Traps, cp.Limits = scope.Counts(), traps.Counts(), limits.Counts()
+		if err := cp.Save(checkpointDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to save checkpoint to %v: %v\n", checkpointDir, err)
+		}
+	}
+	crawlDone := make(chan struct{})
+	checkpointsDone := make(chan struct{})
+	if checkpointDir != "" && CheckpointInterval > 0 {
+		ticker := time.NewTicker(CheckpointInterval)
+		go func() {
+			defer close(checkpointsDone)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-crawlDone:
+					return
+				case <-ticker.C:
+					work.Pause()
+					saveCheckpoint()
+					work.Unpause()
+				}
+			}
+		}()
+	} else {
+		close(checkpointsDone)
+	}
+	
+	// a fixed pool of workers takes requests from the frontier until it is empty
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				request, ok := work.Pop()
+				if !ok {
+					return
+				}
+				process(request)
+				work.Done()
+			}
+		}()
 	}
-	fmt.Printf("\n")
-	return crawlSummary{uniquePages, uniqueTerms}
+	workers.Wait()
+	close(crawlDone)
+	<-checkpointsDone
+	if checkpointDir != "" {
+		saveCheckpoint()
+	}
+	
+	progressf("\n")
+	return crawlSummary{uniquePages, uniqueTerms, crawlErrors, scope.Rejects(), traps.Traps(), limits.Stopped(), limits.HostLimited(), notIndexed, truncated, duplicates}
+}
+
+// Record the results for a page requested as requestURL in the index and titles,
+// unless doIndexing is false because an earlier crawl already indexed it.
+// Returns the number of unique terms added, and whether the page was a
+// near-duplicate of one already indexed.
+func recordPage (requestURL string, depth int, doIndexing bool, results UrlParseResults, visited *VisitedMap, index *Index, titles *URLtitles) (int, bool) {
+	// Pages are recorded under the url any redirects ended up at, which
+	// counts as visited too
+	pageURL := requestURL
+	if results.FinalURL != "" {
+		if cleanFinalURL := normalizeURL(results.FinalURL); cleanFinalURL != requestURL {
+			if visitedKey(cleanFinalURL) != visitedKey(requestURL) {
+				_, finalNew := visited.CheckAndVisit(visitedKey(cleanFinalURL), depth)
+				doIndexing = doIndexing && finalNew
+			}
+			pageURL = cleanFinalURL
+		}
+	}
+	// and duplicates of a page under other urls are indexed once, under
+	// the url the page names as canonical
+	if results.Canonical != "" {
+		if results.Canonical != pageURL {
+			if visitedKey(results.Canonical) != visitedKey(pageURL) {
+				_, canonicalNew := visited.CheckAndVisit(visitedKey(results.Canonical), depth)
+				doIndexing = doIndexing && canonicalNew
+			}
+			pageURL = results.Canonical
+		}
+	}
+	
+	if !doIndexing {
+		return 0, false
+	}
+	if !results.Parsed() || results.NoIndex {
+		// recorded for the crawl report, but not found by metadata searches
+		info := results.PageInfo()
+		info.Meta = nil
+		titles.AddPage(pageURL, info)
+		return 0, false
+	}
+	if titles.AddPageUnlessDuplicate(pageURL, results.PageInfo()) != "" {
+		return 0, true
+	}
+	_, unique := index.Add(pageURL, results.Index)
+	return unique, false
 }
 
 // config variables
 
 var version = "1.0.0"
-var CrawlForeign = false
 var Concurrency = 10
 var MaxDepth = 2
 
+// failed URLs from the most recent crawl, shown by the errors command
+var lastCrawlErrors []crawlError
 
 func main() {
 
-	fmt.Printf("Searcher %v initializing\n", version)
+	LoadConfig()
+
+	progressf("Searcher %v initializing\n", version)
 	// Set up our main data structures 
-	index := Index{entries: make(map[string][]IndexEntry)}
-	visited := VisitedMap{v: make(map[string]int)}
-	titles := URLtitles{titles: make(map[string]string)}
+	index := &Index{entries: make(map[string][]IndexEntry)}
+	visited := &VisitedMap{v: make(map[string]int)}
+	titles := newURLtitles()
 	
 	InitializePunctuation()
 	
 	reader := bufio.NewReader(os.Stdin)
 	cliLoop:
 	for {
-		fmt.Print("> ")
-		lineIn, _ := reader.ReadString('\n')
+		progressf("> ")
+		lineIn, err := reader.ReadString('\n')
+		if err != nil && lineIn == "" {
+			// end of input, e.g. commands piped in from a script
+			break
+		}
 		lineIn = strings.Replace(lineIn, "\r\n", "", -1)
 		lineIn = strings.Replace(lineIn, "\n", "", -1)
 		lineIn = strings.Trim(lineIn, " ");
 		command := strings.SplitN(lineIn, " ", 2)
+		if len(command) < 2 {
+			command = append(command, "")
+		}
 		
 		switch command[0] {
 			case "index", "i": 
@@ -445,18 +924,46 @@ func main() {
 					fmt.Printf ("index command needs a url to crawl\n")
 					Help()
 				}
+			case "indexdir":
+				if command[1] != "" {
+					IndexDir(command[1], visited, index, titles)
+				} else {
+					fmt.Printf ("indexdir command needs a directory to index\n")
+				}
 			case "search", "s": 
 				if command[1] != "" {
-					DisplayTerm(command[1], index, titles) 
+					Search(command[1], index, titles)
 				} else {
 					fmt.Printf ("search command needs a term to look for\n")
 					Help()
 				}
+			case "next", "n":
+				NextPage(1)
+			case "prev", "p":
+				NextPage(-1)
 		
+			case "importwarc":
+				if command[1] != "" {
+					ImportWARC(command[1], visited, index, titles)
+				} else {
+					fmt.Printf ("importwarc command needs a WARC file to import\n")
+				}
+			case "resume":
+				if command[1] != "" {
+					Resume(command[1], visited, index, titles)
+				} else {
+					fmt.Printf ("resume command needs a checkpoint directory\n")
+				}
+			case "scope":
+				Scope(command[1])
+			case "errors":
+				renderErrors(lastCrawlErrors)
+			case "duplicates":
+				renderDuplicates(titles.Duplicates())
 			case "clear": 
 				Reset(visited, index, titles)
 			case "config": 
-				ShowConfig()
+				Config(command[1])
 			case "set": 
 				Set(command[1])
 			case "quit", "q": 
@@ -469,14 +976,14 @@ func main() {
 		
 	}
 	
-	fmt.Printf("Searcher terminating...\n")
+	progressf("Searcher terminating...\n")
 	
 	
 }
 
 // CLI commands and utilities follow
 
-func IndexURL (rooturl string, visited VisitedMap, index Index, titles URLtitles) {
+func IndexURL (rooturl string, visited *VisitedMap, index *Index, titles *URLtitles) {
 	parsedUrl, err := url.Parse(rooturl)
 	if err != nil {
 		fmt.Printf("URL %v doesn't look good %v %+v\n", rooturl, err, parsedUrl)
@@ -489,126 +996,236 @@ func IndexURL (rooturl string, visited VisitedMap, index Index, titles URLtitles
 	}
 	
 	
-	fmt.Printf("Initiating crawl of %v \n", rooturl)
+	progressf("Initiating crawl of %v \n", rooturl)
 	results := Crawl(rooturl, MaxDepth, Concurrency, visited, index, titles)
-	fmt.Printf("Indexed %v pages and %v terms\n\n", results.uniquePages, results.uniqueTerms)
+	lastCrawlErrors = results.errors
+	renderCrawlSummary(rooturl, results)
 	return
 }
 
-func DisplayTerm (term string, index Index, titles URLtitles) {
+// The last result set, paged through by the next and prev commands
+type searchPage struct {
+	term          string
+	results       []searchResult
+	limit, offset int
+}
+
+var lastSearch searchPage
+
+// Parse the search arguments: [-n limit] [-p page] [-offset n] [meta.key:value...] term
+func Search (args string, index *Index, titles *URLtitles) {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	flags.SetOutput(os.Stdout)
+	limit := flags.Int("n", 0, "number of results to show, 0 shows them all")
+	page := flags.Int("p", 0, "page of results to show, starting at 1")
+	offset := flags.Int("offset", 0, "number of results to skip")
+	if err := flags.Parse(strings.Fields(args)); err != nil {
+		return
+	}
+	if *limit < 0 || *page < 0 || *offset < 0 {
+		fmt.Printf("search limit, page and offset must not be negative\n")
+		return
+	}
+	if flags.NArg() == 0 {
+		fmt.Printf ("search command needs a term to look for\n")
+		return
+	}
+	if *page > 0 && *limit == 0 {
+		fmt.Printf("search -p needs -n to set how many results make a page\n")
+		return
+	}
+	if *page > 0 {
+		*offset = (*page - 1) * *limit
+	}
+	var terms []string
+	var filters []metaFilter
+	for _, arg := range flags.Args() {
+		if filter, ok := parseMetaFilter(arg); ok {
+			filters = append(filters, filter)
+		} else {
+			terms = append(terms, arg)
+		}
+	}
+	DisplayTerm(strings.Join(terms, " "), filters, *limit, *offset, index, titles)
+}
+
+// Show the pages containing term whose metadata matches the filters.  With no
+// term every page matching the filters is shown.
+func DisplayTerm (term string, filters []metaFilter, limit, offset int, index *Index, titles *URLtitles) {
 	if !CaseSensitive {
 		term = strings.ToLower(term)
 	}
-	termList := index.GetTerm(term) 
-	if termList == nil {
-		fmt.Printf("Search term \"%v\" not found\n\n", term)
-		return
+	var results []searchResult
+	if term == "" {
+		for _, u := range titles.MatchMeta(filters) {
+			page, _ := titles.GetPage(u)
+			results = append(results, searchResult{page.Title, u, 0, 0, page.Description})
+		}
 	}
-	fmt.Printf("Found %v results for search term \"%v\" :\n", len(termList), term)
-	for _, entry := range termList {
-		title, ok := titles.Get(entry.URL)
+	for _, entry := range index.GetTerm(term) {
+		page, ok := titles.GetPage(entry.URL)
 		if !ok {
-			title = "UNKNOWN"
+			page.Title = "UNKNOWN"
+		}
+		if !matchAll(filters, page.Meta) {
+			continue
 		}
-		fmt.Printf("%v\n%v\nOccurences: %v\n\n", title,  entry.URL, entry.Count)
+		results = append(results, searchResult{page.Title, entry.URL, ScoreEntry(entry), entry.Count, page.Description})
 	}
+	// show the filters as part of the search in the results
+	query := term
+	for _, f := range filters {
+		query = strings.TrimSpace(query + " " + f.String())
+	}
+	lastSearch = searchPage{query, results, limit, offset}
+	displayPage(lastSearch)
 	return
 }
 
-func Reset (visited VisitedMap, index Index, titles URLtitles) {
+// Move forward (1) or back (-1) through the last result set
+func NextPage (direction int) {
+	if lastSearch.term == "" {
+		fmt.Printf("No previous search to page through\n\n")
+		return
+	}
+	if lastSearch.limit == 0 {
+		fmt.Printf("All results were shown, use search -n to page through them\n\n")
+		return
+	}
+	offset := lastSearch.offset + direction * lastSearch.limit
+	if offset < 0 || offset >= len(lastSearch.results) {
+		fmt.Printf("No more results for \"%v\"\n\n", lastSearch.term)
+		return
+	}
+	lastSearch.offset = offset
+	displayPage(lastSearch)
+}
+
+func displayPage (p searchPage) {
+	start, end := p.offset, len(p.results)
+	if start > end {
+		start = end
+	}
+	if p.limit > 0 && start + p.limit < end {
+		end = start + p.limit
+	}
+	renderSearchResults(p.term, len(p.results), start, p.results[start:end])
+}
+
+// The ranking value reported with each search result.  Results are ordered by
+// occurrence count, so for now the score is the count itself.
+func ScoreEntry (entry IndexEntry) float64 {
+	return float64(entry.Count)
+}
+
+func Reset (visited *VisitedMap, index *Index, titles *URLtitles) {
 	index.Reset()
 	visited.Reset()
 	titles.Reset()
-	fmt.Printf("Reset Index\n\n")
+	lastCrawlErrors = nil
+	progressf("Reset Index\n\n")
 
 } 
 
 func Help() {
 	fmt.Printf("This search will crawl a URL and index the terms it finds. It will follow embedded links to a depth of 3, \n")
-	fmt.Printf("however it will only follow links with the same hostname as that originally supplied. \n\n")
+	fmt.Printf("however it will only follow links within the domain of the url originally supplied. \n\n")
 	fmt.Printf("The following commands are available:\n\n")
-	fmt.Printf("\t index (url) \tThis will search and index the specified url and the links\n")
-	fmt.Printf("\t search (term) \tThis will return the pages' URLS, titles and count that contain the search term\n")
+	fmt.Printf("\t index (url) \tThis will search and index the specified url and the links, which may be a file:// url\n")
+	fmt.Printf("\t indexdir (directory) \tThis will index the files in the directory and its subdirectories\n")
+	fmt.Printf("\t resume (directory) \tThis will continue the crawl saved in the checkpoint directory\n")
+	fmt.Printf("\t importwarc (file...) \tThis will index the pages archived in WARC files, without fetching anything\n")
+	fmt.Printf("\t search [-n limit] [-p page] [-offset n] [meta.key:value...] (term) \tThis will return the pages' URLS, titles and count that contain the search term, and whose metadata matches the filters\n")
+	fmt.Printf("\t next | prev \tThis will show the next or previous page of the last search\n")
+	fmt.Printf("\t scope add include|exclude (pattern) \tThis will limit the links crawled to URLs matching, or not matching, a glob or re: regex\n")
+	fmt.Printf("\t scope remove (pattern) | scope list \tThis will remove or list the scope rules\n")
+	fmt.Printf("\t errors \tThis will list the pages that could not be retrieved in the last crawl\n")
+	fmt.Printf("\t duplicates \tThis will list the pages that were not indexed because they are near-duplicates of another page\n")
 	fmt.Printf("\t clear \tThis will reset the index\n")
 	fmt.Printf("\t config \tThis will show configuration settings\n")
+	fmt.Printf("\t config save [file] \tThis will write the configuration settings to the config file\n")
 	fmt.Printf("\t quit \tThis will quit the program\n")
 	fmt.Printf("\n\t set (argument) \t\tset the configuration variable accordingly. Arguments are:\n")
 	fmt.Printf("\t\tcase | nocase\tdefine case sensitivity for terms.  nocase means terms will be converted to lowercase prior to saving in the index\n")
 	fmt.Printf("\t\tindexanchors | noindexanchors\tdefines whether or to index the title attribute on an anchor tag\n")
-	fmt.Printf("\t\tcrawlforeign | nocrawlforeign\tdefines whether or to crawl links pointed to hosts outside the root domain\n")
+	fmt.Printf("\t\textract full | main\tindex all the text of a page, or only its main content\n")
+	fmt.Printf("\t\tindexalt | noindexalt\tdefines whether or not to index the alt text of images\n")
+	fmt.Printf("\t\tindexdescription | noindexdescription\tdefines whether or not to index the meta description\n")
+	fmt.Printf("\t\tdomainpolicy host | domain | list | any\tdefines which hosts links are followed to\n")
+	fmt.Printf("\t\talloweddomains (domain,domain...)\tthe domains also followed by the list domain policy\n")
+	fmt.Printf("\t\tlinksources (a,area,frame,iframe,link,refresh)\tthe kinds of tag links are followed from\n")
+	fmt.Printf("\t\ttrackingparams (name,name...)\tquery parameters removed from urls, * matches any characters\n")
+	fmt.Printf("\t\tmergewww | nomergewww\tdefines whether hosts with and without a leading www. are crawled as one site\n")
 	fmt.Printf("\t\tconcurrency (integer) Number of concurrent crawls.  Must be 1 or more\n")
 	fmt.Printf("\t\tdepth (integer) Number of levels to crawl, the root url being level 1.  Must be 1 or more\n")
+	fmt.Printf("\t\tmaxpages | maxbytes | maxhostpages (integer) \tstop a crawl after this many pages or bytes, or crawl at most this many pages per host.  0 for no limit\n")
+	fmt.Printf("\t\tmaxduration (duration) \tstop a crawl after this long, e.g. 10m.  0 for no limit\n")
+	fmt.Printf("\t\tcheckpointdir (directory) \tsave checkpoints of each crawl here so it can be resumed.  Empty for none\n")
+	fmt.Printf("\t\tcheckpointinterval (duration) \thow often to save a checkpoint, e.g. 1m\n")
+	fmt.Printf("\t\twarcdir (directory) \tarchive every request and response of each crawl to WARC files here.  Empty for none\n")
+	fmt.Printf("\t\twarcmaxsize (integer) \tstart a new WARC file once one is this many bytes.  0 for no limit\n")
+	fmt.Printf("\t\tuseragent (string) \tthe User-Agent header sent with each request\n")
+	fmt.Printf("\t\tconnecttimeout | readtimeout | totaltimeout (duration) \ttime allowed to connect, for the server to respond or send more and for the whole fetch\n")
+	fmt.Printf("\t\theaders (Name: value;;...) \textra headers sent with each request\n")
+	fmt.Printf("\t\tproxy (url) \tHTTP(S) proxy, empty to use the HTTP_PROXY environment\n")
+	fmt.Printf("\t\ttransport live | record | replay \tfetch from the network, also record each response to fixturedir, or replay them from there\n")
+	fmt.Printf("\t\tfixturedir (directory) \twhere responses are recorded to and replayed from\n")
+	fmt.Printf("\t\ttlsinsecure | notlsinsecure \tdefines whether or not to skip verifying server certificates\n")
+	fmt.Printf("\t\ttlsminversion 1.0 | 1.1 | 1.2 | 1.3 \tthe oldest TLS version accepted\n")
+	fmt.Printf("\t\tmaxredirects (integer) \thow many redirects to follow for a page\n")
+	fmt.Printf("\t\tmaxbodysize | maxtokens (integer) \tthe most bytes read and terms indexed from any page.  0 for no limit\n")
+	fmt.Printf("\t\tfileinclude | fileexclude (glob,glob...) \tthe local files indexed, and the files and directories skipped\n")
+	fmt.Printf("\t\tmaxfilesize (integer) \tlocal files larger than this many bytes are skipped.  0 for no limit\n")
+	fmt.Printf("\t\tmaxpathdepth | maxsegmentrepeats | maxquerylength | maxtemplateurls (integer) \tlinks beyond these are reported as crawler traps.  0 for no limit\n")
+	fmt.Printf("\t\tmaxtermlength (integer) \tterms longer than this many characters are not indexed.  0 for no limit\n")
+	fmt.Printf("\t\tduplicatedistance (integer) \tpages whose fingerprints differ in at most this many bits are near-duplicates.  0 to index every page\n")
+	fmt.Printf("\t\tmaxattempts (integer) \thow many times in all to try a page that fails with an error that may be temporary\n")
+	fmt.Printf("\t\tretrybackoff | retrymaxwait (duration) \tthe wait before the first retry, doubling after each, and the longest wait\n")
+	fmt.Printf("\t\toutput text | json | csv | tsv\tformat used for search results, crawl summaries, config and error reports\n")
 
 	
 
 }
 
 func ShowConfig() {
-	fmt.Printf("Configuration settings:\n")
-	fmt.Printf("\tCase Sensitive %v\tIf false, convert terms to lower case before indexing\n", CaseSensitive)
-	fmt.Printf("\tIndex Anchors %v\tIf true, index the titles of anchor tags\n", IndexAnchorTitles)
-	fmt.Printf("\tCrawl Foreign %v\tIf true, crawl links to URLs outside of the root URL domain\n", CrawlForeign)
-	fmt.Printf("\tMaximum Depth %v\t\tHow many levels of embedded links to crawl\n", MaxDepth + 1)
-	fmt.Printf("\tConcurrency %v\t\tHow many concurrent pages to crawl\n", Concurrency)
-	
+	var config []configSetting
+	for _, s := range settings {
+		config = append(config, configSetting{s.label, s.name, s.value(), s.source, s.description})
+	}
+	renderConfig(config)
 }
 
+// Set a configuration variable: set name [value].  Boolean settings may also be
+// given as set name or set noname.
 func Set(command string) {
 
 	commandArgs := strings.SplitN(command, " ", 2)
-	switch commandArgs[0] {
-		case "case": 
-			CaseSensitive = true
-			fmt.Printf("Indexing is now case sensitive\n")
-		case "nocase":
-			CaseSensitive = false
-			fmt.Printf("Indexing is now case insensitive\n")
-		case "indexanchors":
-			IndexAnchorTitles = true
-			fmt.Printf("Anchor titles will be indexed\n")
-		case "noindexAnchors":
-			IndexAnchorTitles = false
-			fmt.Printf("Anchor titles will not be indexed\n")
-		case "crawlforeign":
-			CrawlForeign = true
-			fmt.Printf("Links outside of the root domain will be searched\n")
-		case "nocrawlforeign":
-			CrawlForeign = false
-			fmt.Printf("Links outside of the root domain will not be searched\n")
-		case "concurrency": 
-			i, err := strconv.Atoi(commandArgs[1])
-			if err != nil {
-				fmt.Printf("%v not integer: %v\n", commandArgs[1], err)
-				break
-			}
-			
-			if i < 1 {
-				fmt.Printf("Concurrency must be greater than 0\n")
-				break
-			}
-			Concurrency = i;
-			fmt.Printf("Concurrency set to %v\n", Concurrency)
-			
-		case "depth": 
-			i, err := strconv.Atoi(commandArgs[1])
-			if err != nil {
-				fmt.Printf("%v not integer: %v\n", commandArgs[1], err)
-					break
-				}
-					
-				if i < 1 {
-					fmt.Printf("Depth must be greater than 0\n")
-					break
-				}
-			MaxDepth = i - 1
-			fmt.Printf("Depth set to %v\n", MaxDepth + 1)
-			
-			
-					
-		default:
-			Help()
-					
+	name, arg := commandArgs[0], ""
+	if len(commandArgs) > 1 {
+		arg = strings.TrimSpace(commandArgs[1])
+	}
+	s := findSetting(strings.ToLower(name))
+	if s != nil && s.isBool() && arg == "" {
+		arg = "true"
+	}
+	if s == nil && strings.HasPrefix(name, "no") {
+		s = findSetting(strings.ToLower(strings.TrimPrefix(name, "no")))
+		if s != nil && s.isBool() {
+			arg = "false"
+		} else {
+			s = nil
 		}
+	}
+	if s == nil {
+		Help()
+		return
+	}
+	if err := s.set(arg); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	s.source = "set"
+	progressf("%v set to %v\n", s.label, s.value())
 }
 
 func SortEntries (e []IndexEntry) []IndexEntry {
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// main sets up the punctuation stripped from terms, so the tests do too
+func TestMain(m *testing.M) {
+	InitializePunctuation()
+	os.Exit(m.Run())
+}
+
+// A site with a page of each kind the crawl must tell apart
+func gatingSite() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Home</title></head><body><p>welcome penguins</p>
+<a href="/page.html">page</a> <a href="/photo.png">photo</a> <a href="/missing.html">missing</a>
+<a href="/sniffed">sniffed</a> <a href="/moved">moved</a></body></html>`)
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Page</title></head><body><p>walruses</p></body></html>`)
+	})
+	mux.HandleFunc("/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, `<p>ostriches</p> <a href="/hidden.html">hidden</a>`)
+	})
+	mux.HandleFunc("/missing.html", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<html><body><p>puffins</p></body></html>`)
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, `<html><head><title>Sniffed</title></head><body><p>narwhals</p></body></html>`)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page.html", http.StatusMovedPermanently)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGetURLGating(t *testing.T) {
+	server := gatingSite()
+	defer server.Close()
+	tests := []struct {
+		path, contentType, term string
+		status                  int
+		indexed, failed         bool
+	}{
+		{"/page.html", "text/html; charset=utf-8", "walruses", 200, true, false},
+		{"/photo.png", "image/png", "ostriches", 200, false, false},
+		{"/missing.html", "", "puffins", 404, false, true},
+		{"/sniffed", "text/html; charset=utf-8", "narwhals", 200, true, false},
+	}
+	for _, test := range tests {
+		results := GetURL(server.URL + test.path)
+		if results.Status != test.status || (results.Err != nil) != test.failed {
+			t.Errorf("%v got status %v and error %v, want %v", test.path, results.Status, results.Err, test.status)
+		}
+		if results.Parsed() != test.indexed || (results.Index[test.term] > 0) != test.indexed {
+			t.Errorf("%v parsed %v with %v indexed %v times, want %v", test.path, results.Parsed(), test.term, results.Index[test.term], test.indexed)
+		}
+		if results.ContentType != test.contentType {
+			t.Errorf("%v has type %q, want %q", test.path, results.ContentType, test.contentType)
+		}
+		if test.path == "/photo.png" && len(results.EmbeddedURL) != 0 {
+			t.Errorf("photo.png has links %v, want none", results.EmbeddedURL)
+		}
+	}
+
+	results := GetURL(server.URL + "/moved")
+	if results.FinalURL != server.URL+"/page.html" || results.Index["walruses"] != 1 {
+		t.Errorf("redirect ended at %v, want page.html indexed", results.FinalURL)
+	}
+}
+
+// Pages that aren't 2xx HTML are recorded but not indexed, and their links aren't followed
+func TestCrawlGating(t *testing.T) {
+	server := gatingSite()
+	defer server.Close()
+	visited := &VisitedMap{v: make(map[string]int)}
+	index := &Index{entries: make(map[string][]IndexEntry)}
+	titles := &URLtitles{titles: make(map[string]PageInfo)}
+	results := Crawl(server.URL, 2, 1, visited, index, titles)
+
+	if results.notIndexed != 1 || len(results.errors) != 1 {
+		t.Errorf("got %v pages not indexed and errors %v, want photo.png and missing.html", results.notIndexed, results.errors)
+	}
+	for term, want := range map[string]int{"welcome": 1, "walruses": 1, "narwhals": 1, "ostriches": 0, "puffins": 0} {
+		if got := len(index.GetTerm(term)); got != want {
+			t.Errorf("%v found on %v pages, want %v", term, got, want)
+		}
+	}
+	for key := range visited.v {
+		if strings.Contains(key, "hidden") {
+			t.Errorf("followed a link from an image to %v", key)
+		}
+	}
+	if info, ok := titles.GetPage(server.URL + "/photo.png"); !ok || info.Status != 200 || info.ContentType != "image/png" {
+		t.Errorf("photo.png recorded as %+v", info)
+	}
+}
+
+// Links are found in each kind of tag, remembering which kinds each was found in
+func TestGetURLLinkSources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Links</title>
+<link rel="next" href="/next.html"><link rel="prev" href="/prev.html"><link rel="stylesheet" href="/style.css">
+<meta http-equiv="Refresh" content="5; URL='/refresh.html'">
+</head><body><a href="/a.html">a</a> <a href="#top">top</a> <a href="/nofollow.html" rel="nofollow">nf</a>
+<map name="m"><area shape="rect" coords="0,0,1,1" href="/area.html"></map>
+<frameset><frame src="/frame.html"></frameset><iframe src="/a.html"></iframe></body></html>`)
+	}))
+	defer server.Close()
+
+	results := GetURL(server.URL + "/")
+	want := map[string][]string{
+		"/a.html":       {"a", "iframe"},
+		"/area.html":    {"area"},
+		"/frame.html":   {"frame"},
+		"/next.html":    {"link"},
+		"/prev.html":    {"link"},
+		"/refresh.html": {"refresh"},
+	}
+	got := make(map[string][]string)
+	for link := range results.EmbeddedURL {
+		got[strings.TrimPrefix(link, server.URL)] = results.LinkSources[link]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got links %v, want %v", got, want)
+	}
+}
+
+func TestRefreshURL(t *testing.T) {
+	tests := map[string]string{
+		"0; url=/next.html":         "/next.html",
+		"5;URL='/next.html'":        "/next.html",
+		`3, url = "/next.html"`:     "/next.html",
+		"0; /next.html":             "/next.html",
+		"30":                        "",
+		"0; url=http://x.org/a?b=c": "http://x.org/a?b=c",
+	}
+	for content, want := range tests {
+		if got := refreshURL(content); got != want {
+			t.Errorf("refreshURL(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+// Links only found in disabled kinds of tag are rejected by linksources
+func TestLinkSourcesSetting(t *testing.T) {
+	sources := LinkSources
+	defer func() { LinkSources = sources }()
+	LinkSources = []string{"a", "link"}
+	root, _ := url.Parse("http://example.com/")
+	cs := newCrawlScope(root, nil)
+	for _, test := range []struct {
+		sources []string
+		want    bool
+	}{
+		{[]string{"a"}, true},
+		{[]string{"iframe", "a"}, true},
+		{[]string{"iframe"}, false},
+		{[]string{"refresh", "frame"}, false},
+	} {
+		u, _ := url.Parse("http://example.com/" + strings.Join(test.sources, "-"))
+		if got := cs.AllowSources(u, test.sources); got != test.want {
+			t.Errorf("link from %v allowed %v, want %v", test.sources, got, test.want)
+		}
+	}
+	if rejects := cs.Rejects(); len(rejects) != 1 || rejects[0].Rejected != 2 {
+		t.Errorf("got rejects %v, want 2 by linksources", rejects)
+	}
+}
+
+// Image alt text and the meta description are indexed unless turned off, and
+// the description is kept for snippets
+func TestGetURLAltTextAndDescription(t *testing.T) {
+	alt, description := IndexAltText, IndexDescription
+	defer func() { IndexAltText, IndexDescription = alt, description }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Gallery</title>
+<meta name="Description" content="Photos of
+   puffins"><meta name="description" content="a second description"></head>
+<body><img src="/walrus.jpg" alt="A sleeping walrus"><img src="/spacer.gif"></body></html>`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		alt, description bool
+		walrus, puffins  int
+	}{
+		{true, true, 1, 1},
+		{false, true, 0, 1},
+		{true, false, 1, 0},
+	}
+	for _, test := range tests {
+		IndexAltText, IndexDescription = test.alt, test.description
+		results := GetURL(server.URL + "/")
+		if results.Index["walrus"] != test.walrus || results.Index["puffins"] != test.puffins {
+			t.Errorf("indexalt %v indexdescription %v indexed walrus %v and puffins %v times, want %v and %v",
+				test.alt, test.description, results.Index["walrus"], results.Index["puffins"], test.walrus, test.puffins)
+		}
+		if results.Description != "Photos of puffins" || results.PageInfo().Description != results.Description {
+			t.Errorf("description %q, want the first one with its spacing collapsed", results.Description)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Structured metadata.  OpenGraph <meta property> tags, JSON-LD scripts and
+// schema.org microdata are collected into a map from key to values stored with
+// each page, so searches can filter on them, e.g. meta.brand:acme.
+//
+// Keys are lower case.  OpenGraph keys lose their vocabulary prefix, so og:title
+// is title and product:price:amount is price.amount.  Nested JSON-LD and
+// microdata properties are recorded under their dotted path, e.g. offers.price,
+// and under their own name, e.g. price, and an object's name is also recorded
+// under the object's key, so a brand given as {"@type": "Brand", "name": "Acme"}
+// is found by both meta.brand:acme and meta.brand.name:acme.
+
+// OpenGraph vocabularies recognised in <meta property> tags
+var openGraphPrefixes = []string{"og:", "product:", "article:", "book:", "profile:", "music:", "video:"}
+
+// Elements that never have an end tag, see elementStack
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+type pageMetadata map[string][]string
+
+// Add a value under key, once
+func (m pageMetadata) add(key, value string) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.Join(strings.Fields(value), " ")
+	if key == "" || value == "" {
+		return
+	}
+	for _, v := range m[key] {
+		if v == value {
+			return
+		}
+	}
+	m[key] = append(m[key], value)
+}
+
+// Add a nested property under its dotted path and its own name
+func (m pageMetadata) addPath(prefix []string, name, value string) {
+	m.add(strings.Join(append(append([]string(nil), prefix...), name), "."), value)
+	if len(prefix) > 0 {
+		m.add(name, value)
+	}
+}
+
+// Record an OpenGraph <meta property> tag.  Returns false if the property is not
+// from an OpenGraph vocabulary.
+func (m pageMetadata) OpenGraph(property, content string) bool {
+	property = strings.ToLower(strings.TrimSpace(property))
+	for _, prefix := range openGraphPrefixes {
+		if strings.HasPrefix(property, prefix) {
+			m.add(strings.ReplaceAll(strings.TrimPrefix(property, prefix), ":", "."), content)
+			return true
+		}
+	}
+	return false
+}
+
+// Record the contents of a <script type="application/ld+json"> block.  Blocks
+// that aren't valid JSON are ignored.
+func (m pageMetadata) JSONLD(text string) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return
+	}
+	m.addJSON(nil, "", v)
+}
+
+func (m pageMetadata) addJSON(prefix []string, name string, v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			m.addJSON(prefix, name, item)
+		}
+	case map[string]interface{}:
+		path := prefix
+		if name != "" && name != "@graph" {
+			path = append(append([]string(nil), prefix...), name)
+			if objectName, ok := v["name"].(string); ok {
+				m.addPath(prefix, name, objectName)
+			}
+		}
+		for key, value := range v {
+			if key == "@context" || key == "@id" {
+				continue
+			}
+			m.addJSON(path, strings.TrimPrefix(key, "@"), value)
+		}
+	case string:
+		if name == "type" {
+			v = schemaType(v)
+		}
+		m.addPath(prefix, name, v)
+	case float64:
+		m.addPath(prefix, name, strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		m.addPath(prefix, name, strconv.FormatBool(v))
+	}
+}
+
+// The type name from a schema.org type url, e.g. Product for https://schema.org/Product
+func schemaType(itemtype string) string {
+	itemtype = strings.TrimRight(strings.TrimSpace(itemtype), "/")
+	if i := strings.LastIndexAny(itemtype, "/#"); i >= 0 {
+		return itemtype[i+1:]
+	}
+	return itemtype
+}
+
+// An itemscope, or an itemprop waiting for the text of its element
+type microdataItem struct {
+	path  []string
+	depth int
+	text  strings.Builder
+	prop  string
+}
+
+// Follows the open elements of a page to collect its microdata
+type microdataParser struct {
+	meta  pageMetadata
+	open  elementStack
+	items []*microdataItem
+	props []*microdataItem
+}
+
+func newMicrodataParser(meta pageMetadata) *microdataParser {
+	return &microdataParser{meta: meta}
+}
+
+// Handle a start tag.  selfClosing tags and tags whose contents are skipped,
+// such as scripts, don't open an element.
+func (mp *microdataParser) StartTag(token html.Token, selfClosing bool) {
+	var itemprop, itemtype string
+	var itemscope bool
+	var value string
+	hasValue := false
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "itemprop":
+			itemprop = attr.Val
+		case "itemscope":
+			itemscope = true
+		case "itemtype":
+			itemtype = attr.Val
+		case "content", "datetime":
+			value, hasValue = attr.Val, true
+		case "href", "src":
+			if !hasValue {
+				value, hasValue = attr.Val, true
+			}
+		}
+	}
+	opens := opensElement(token, selfClosing)
+	if opens {
+		mp.open.Push(token.Data)
+	}
+
+	var path []string
+	if len(mp.items) > 0 {
+		path = mp.items[len(mp.items)-1].path
+	}
+	props := strings.Fields(itemprop)
+	if len(mp.items) == 0 && !itemscope {
+		// itemprops outside any item aren't microdata
+		props = nil
+	}
+
+	if itemscope {
+		itemPath := path
+		if len(props) > 0 {
+			itemPath = append(append([]string(nil), path...), strings.ToLower(props[0]))
+		}
+		if itemtype != "" {
+			mp.meta.addPath(itemPath, "type", schemaType(itemtype))
+		}
+		if opens {
+			mp.items = append(mp.items, &microdataItem{path: itemPath, depth: len(mp.open), prop: strings.Join(props, " ")})
+		}
+		return
+	}
+	for _, prop := range props {
+		if hasValue || !opens {
+			mp.meta.addPath(path, prop, value)
+		} else {
+			mp.props = append(mp.props, &microdataItem{path: path, depth: len(mp.open), prop: prop})
+		}
+	}
+}
+
+func (mp *microdataParser) Text(text string) {
+	for _, p := range mp.props {
+		p.text.WriteString(text)
+		p.text.WriteString(" ")
+	}
+}
+
+// Handle an end tag, closing any elements left open inside it
+func (mp *microdataParser) EndTag(name string) {
+	if !mp.open.Pop(name) {
+		return
+	}
+	for len(mp.props) > 0 && mp.props[len(mp.props)-1].depth > len(mp.open) {
+		p := mp.props[len(mp.props)-1]
+		mp.meta.addPath(p.path, p.prop, p.text.String())
+		mp.props = mp.props[:len(mp.props)-1]
+	}
+	for len(mp.items) > 0 && mp.items[len(mp.items)-1].depth > len(mp.open) {
+		item := mp.items[len(mp.items)-1]
+		mp.items = mp.items[:len(mp.items)-1]
+		// an item's name stands for the item, e.g. brand for brand.name
+		if item.prop != "" {
+			parent, key := item.path[:len(item.path)-1], item.path[len(item.path)-1]
+			for _, name := range mp.meta[strings.Join(append(append([]string(nil), item.path...), "name"), ".")] {
+				mp.meta.addPath(parent, key, name)
+			}
+		}
+	}
+}
+
+// A meta.key:value search filter.  The value may use * and ? wildcards, as in
+// scope rules, or be a numeric range such as 10..20, with either end left open.
+type metaFilter struct {
+	key, value string
+	re         *regexp.Regexp
+}
+
+func parseMetaFilter(arg string) (metaFilter, bool) {
+	if !strings.HasPrefix(arg, "meta.") {
+		return metaFilter{}, false
+	}
+	key, value, ok := strings.Cut(strings.TrimPrefix(arg, "meta."), ":")
+	if !ok || key == "" {
+		return metaFilter{}, false
+	}
+	value = strings.ToLower(value)
+	return metaFilter{strings.ToLower(key), value, regexp.MustCompile("^" + globToRegexp(value) + "$")}, true
+}
+
+func (f metaFilter) String() string {
+	return fmt.Sprintf("meta.%v:%v", f.key, f.value)
+}
+
+func (f metaFilter) matchValue(v string) bool {
+	v = strings.ToLower(v)
+	if low, high, ok := strings.Cut(f.value, ".."); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(v, "$")), 64)
+		if err != nil {
+			return false
+		}
+		if low != "" {
+			if l, err := strconv.ParseFloat(low, 64); err != nil || n < l {
+				return false
+			}
+		}
+		if high != "" {
+			if h, err := strconv.ParseFloat(high, 64); err != nil || n > h {
+				return false
+			}
+		}
+		return true
+	}
+	return f.re.MatchString(v)
+}
+
+// Whether any value of the filter's key matches
+func (f metaFilter) Match(meta map[string][]string) bool {
+	for _, v := range meta[f.key] {
+		if f.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAll(filters []metaFilter, meta map[string][]string) bool {
+	for _, f := range filters {
+		if !f.Match(meta) {
+			return false
+		}
+	}
+	return true
+}
+
+// The urls of the pages whose metadata matches all the filters
+func (ut *URLtitles) MatchMeta(filters []metaFilter) []string {
+	ut.mux.Lock()
+	defer ut.mux.Unlock()
+	var urls []string
+	for u, info := range ut.titles {
+		if info.DuplicateOf == "" && matchAll(filters, info.Meta) {
+			urls = append(urls, u)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
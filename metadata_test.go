@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const productJSONLD = `<script type="application/ld+json">{"@type": "Product", "brand": {"@type": "Brand", "name": "Acme"}}</script>`
+
+func TestMatchMetaSkipsNoIndexPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Skates</title>%v</head><body><p>rocket skates</p><a href="/hidden.html">hidden</a></body></html>`, productJSONLD)
+	})
+	mux.HandleFunc("/hidden.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Hidden</title><meta name="robots" content="noindex">%v</head><body><p>secret skates</p></body></html>`, productJSONLD)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	visited, index, titles := newTestIndex()
+	results := Crawl(server.URL, 1, 1, visited, index, titles)
+	if results.uniquePages != 2 || results.notIndexed != 1 {
+		t.Fatalf("crawled %v pages with %v not indexed, want 2 and 1", results.uniquePages, results.notIndexed)
+	}
+
+	filter, _ := parseMetaFilter("meta.brand:acme")
+	got := titles.MatchMeta([]metaFilter{filter})
+	want := []string{normalizeURL(server.URL)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchMeta(meta.brand:acme) = %v, want %v", got, want)
+	}
+}
+
+func TestMetaFilterMatch(t *testing.T) {
+	meta := map[string][]string{"brand": {"Acme"}, "price": {"$12.50"}, "title": {"Rocket Skates"}, "url": {"https://example.com/skates/rocket"}}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"meta.brand:acme", true},
+		{"meta.brand:other", false},
+		{"meta.title:rocket*", true},
+		{"meta.price:10..20", true},
+		{"meta.price:..10", false},
+		{"meta.price:12.5..", true},
+		{"meta.color:red", false},
+		{"meta.url:https://*", true},
+		{"meta.url:*/skates/*", true},
+		{"meta.url:http://*", false},
+	}
+	for _, test := range tests {
+		filter, ok := parseMetaFilter(test.filter)
+		if !ok {
+			t.Fatalf("parseMetaFilter(%q) failed", test.filter)
+		}
+		if got := filter.Match(meta); got != test.want {
+			t.Errorf("%v matched %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// URL normalization.  Every url is normalized before it is checked in the
+// VisitedMap or the frontier or recorded in the index and titles, so that the
+// same page reached through differently written links is only crawled and
+// indexed once.  The scheme and host are lowercased and default ports dropped,
+// percent-escapes of unreserved characters are decoded and the rest uppercased,
+// dot segments and directory index pages such as index.html are removed,
+// tracking and session parameters are stripped and the remaining query
+// parameters sorted.  The fragment is dropped, since it never changes the page
+// fetched.  The normalized form is only a key: pages are fetched from the url as
+// linked, since /dir/ may not serve the same page as /dir/index.php.  With
+// MergeWWW set, hosts with and without a leading www. are visited as one.
+
+// Query (and ;path) parameters removed from urls.  * matches any characters.
+var TrackingParams = []string{
+	"utm_*", "gclid", "dclid", "fbclid", "msclkid", "yclid", "mc_cid", "mc_eid", "_ga", "_hsenc", "_hsmi",
+	"igshid", "ref_src", "jsessionid", "phpsessid", "aspsessionid*", "sessionid", "cfid", "cftoken",
+}
+
+var MergeWWW = true
+
+// Last path segments that name the page served for the directory itself
+var directoryIndexes = []string{"index.html", "index.htm", "index.php", "default.htm", "default.html", "default.asp", "default.aspx"}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Whether a query parameter name is one of the TrackingParams
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range TrackingParams {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// Decode the percent-escapes of unreserved characters, which mean the same
+// either way, and uppercase the hex digits of the others
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			hi, ok1 := unhex(s[i+1])
+			lo, ok2 := unhex(s[i+2])
+			if ok1 && ok2 {
+				if c := hi<<4 | lo; isUnreserved(c) {
+					b.WriteByte(c)
+				} else {
+					b.WriteString(strings.ToUpper(s[i : i+3]))
+				}
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Normalize the escaped path of a url: resolve dot segments, drop tracking
+// ;parameters and a trailing directory index page
+func normalizePath(p string, indexes []string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		if name, params, ok := strings.Cut(segment, ";"); ok {
+			kept := []string{name}
+			for _, param := range strings.Split(params, ";") {
+				key, _, _ := strings.Cut(param, "=")
+				if !isTrackingParam(key) {
+					kept = append(kept, param)
+				}
+			}
+			segments[i] = strings.Join(kept, ";")
+		}
+		segments[i] = normalizeEscapes(segments[i])
+	}
+	last := strings.ToLower(segments[len(segments)-1])
+	for _, index := range indexes {
+		if last == index {
+			segments[len(segments)-1] = ""
+			break
+		}
+	}
+	p = strings.Join(segments, "/")
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// Normalize a raw query: drop tracking parameters and sort the rest, keeping the
+// order of repeated parameters
+func normalizeQuery(q string) string {
+	var params []string
+	for _, param := range strings.Split(q, "&") {
+		if param == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if !isTrackingParam(key) {
+			params = append(params, normalizeEscapes(param))
+		}
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		ki, _, _ := strings.Cut(params[i], "=")
+		kj, _, _ := strings.Cut(params[j], "=")
+		return ki < kj
+	})
+	return strings.Join(params, "&")
+}
+
+// The normalized form of an absolute http(s) or file url, used as its key
+// throughout the crawl.  Anything else is returned unchanged.
+func normalizeURL(rawurl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err == nil && u.Opaque == "" && strings.EqualFold(u.Scheme, "file") {
+		return "file://" + normalizePath(u.EscapedPath(), fileDirectoryIndexes)
+	}
+	if err != nil || u.Opaque != "" || u.Host == "" {
+		return rawurl
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return rawurl
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[scheme] {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme + "://")
+	if u.User != nil {
+		b.WriteString(u.User.String() + "@")
+	}
+	b.WriteString(host)
+	b.WriteString(normalizePath(u.EscapedPath(), directoryIndexes))
+	if q := normalizeQuery(u.RawQuery); q != "" {
+		b.WriteString("?" + q)
+	}
+	return b.String()
+}
+
+// The key a url is visited and queued under: its normalized form, without a
+// leading www. if MergeWWW is set
+func visitedKey(rawurl string) string {
+	key := normalizeURL(rawurl)
+	if MergeWWW {
+		if scheme, rest, ok := strings.Cut(key, "://"); ok && (scheme == "http" || scheme == "https") {
+			key = scheme + "://" + strings.TrimPrefix(rest, "www.")
+		}
+	}
+	return key
+}
+
+// Resolve a link found on a page against the page url, without its fragment.
+// Returns false for links that can't be crawled, such as mailto: or
+// javascript:, and for file: links on web pages
+func resolveLink(base *url.URL, href string) (string, bool) {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	link := base.ResolveReference(ref)
+	switch {
+	case link.Scheme == "http" || link.Scheme == "https":
+	case link.Scheme == "file" && base.Scheme == "file":
+	default:
+		return "", false
+	}
+	link.Fragment, link.RawFragment = "", ""
+	return link.String(), true
+}
+
+// The url in the content of a <meta http-equiv="refresh"> tag, such as
+// "5; url=/next.html", or "" if it only reloads the page
+func refreshURL(content string) string {
+	_, rest, ok := strings.Cut(content, ";")
+	if !ok {
+		if _, rest, ok = strings.Cut(content, ","); !ok {
+			return ""
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) >= 4 && strings.EqualFold(rest[:3], "url") {
+		if value := strings.TrimSpace(rest[3:]); strings.HasPrefix(value, "=") {
+			rest = strings.TrimSpace(value[1:])
+		}
+	}
+	return strings.Trim(rest, `"' `)
+}
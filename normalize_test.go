@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"HTTP://Example.COM:80/a/./b/../index.html?utm_source=news&b=2&a=1#top", "http://example.com/a/?a=1&b=2"},
+		{"https://example.com:8443/%7Euser/default.aspx", "https://example.com:8443/~user/"},
+		{"http://example.com/page?sid=42", "http://example.com/page?sid=42"},
+		{"http://example.com/page;jsessionid=abc?phpsessid=1", "http://example.com/page"},
+		{"file:///docs/index.html", "file:///docs/"},
+		{"file:///docs/default.htm", "file:///docs/default.htm"},
+	}
+	for _, test := range tests {
+		if got := normalizeURL(test.in); got != test.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestVisitedKeyMergeWWW(t *testing.T) {
+	merge := MergeWWW
+	defer func() { MergeWWW = merge }()
+	MergeWWW = true
+	if a, b := visitedKey("http://www.example.com/a"), visitedKey("http://example.com/a"); a != b {
+		t.Errorf("with mergewww %q and %q differ", a, b)
+	}
+	MergeWWW = false
+	if a, b := visitedKey("http://www.example.com/a"), visitedKey("http://example.com/a"); a == b {
+		t.Errorf("with nomergewww both are %q", a)
+	}
+}
+
+// Pages are fetched as linked, not from their normalized url
+func TestCrawlFetchesLinkedURL(t *testing.T) {
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<html><body><a href="/shop/index.php?b=2&a=1">shop</a></body></html>`))
+		case "/shop/index.php":
+			w.Write([]byte(`<html><body><p>Rocket skates</p></body></html>`))
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	visited, index, titles := newTestIndex()
+	results := Crawl(server.URL+"/", 1, 1, visited, index, titles)
+	if len(results.errors) != 0 || len(fetched) != 2 || fetched[1] != "/shop/index.php?b=2&a=1" {
+		t.Fatalf("fetched %v with errors %v", fetched, results.errors)
+	}
+	if got := indexedURLs(index, "skates"); len(got) != 1 || got[0] != server.URL+"/shop/?a=1&b=2" {
+		t.Errorf("skates found on %v", got)
+	}
+}
+
+// A local default.htm is indexed, though only index.html and index.htm are
+// served for a directory
+func TestIndexDirDefaultPage(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "default.htm"), []byte(`<html><body><p>Walruses</p></body></html>`), 0644)
+
+	visited, index, titles := newTestIndex()
+	IndexDir(dir, visited, index, titles)
+	if got := indexedURLs(index, "walruses"); len(got) != 1 {
+		t.Errorf("walruses found on %v", got)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Output formats supported by the search, crawl summary, config and error reports.
+// The field names used by json, csv and tsv are part of the documented interface
+// (see README.md) so scripts can rely on them.
+
+var OutputFormat = "text"
+
+var outputFormats = []string{"text", "json", "csv", "tsv"}
+
+func validOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// One row of a search result
+type searchResult struct {
+	Title   string  `json:"title"`
+	URL     string  `json:"url"`
+	Score   float64 `json:"score"`
+	Count   int     `json:"count"`
+	Snippet string  `json:"snippet"`
+}
+
+// A URL that could not be crawled
+type crawlError struct {
+	URL      string `json:"url"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+// One configuration variable as shown by the config command
+type configSetting struct {
+	Label       string `json:"-"`
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Description string `json:"description"`
+}
+
+// Progress messages (prompt, crawl dots) go to stderr when the output is meant
+// for another program, so stdout only carries the report itself.
+func progressf(format string, a ...interface{}) {
+	if OutputFormat == "text" {
+		fmt.Printf(format, a...)
+		return
+	}
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+func writeJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing json: %v\n", err)
+	}
+}
+
+// Write a header and rows as csv or tsv depending on OutputFormat
+func writeTable(header []string, rows [][]string) {
+	w := csv.NewWriter(os.Stdout)
+	if OutputFormat == "tsv" {
+		w.Comma = '\t'
+	}
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %v: %v\n", OutputFormat, err)
+	}
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// Render one page of search results.  total is the number of hits for the term
+// and offset the position of the first result on this page.
+func renderSearchResults(term string, total, offset int, results []searchResult) {
+	switch OutputFormat {
+	case "json":
+		if results == nil {
+			results = []searchResult{}
+		}
+		writeJSON(struct {
+			Term    string         `json:"term"`
+			Total   int            `json:"total"`
+			Offset  int            `json:"offset"`
+			Results []searchResult `json:"results"`
+		}{term, total, offset, results})
+	case "csv", "tsv":
+		// the total is repeated on each row, as a table has nowhere else for it
+		rows := [][]string{}
+		for _, r := range results {
+			rows = append(rows, []string{strconv.Itoa(total), r.Title, r.URL, formatScore(r.Score), strconv.Itoa(r.Count), r.Snippet})
+		}
+		writeTable([]string{"total", "title", "url", "score", "count", "snippet"}, rows)
+	default:
+		if total == 0 {
+			fmt.Printf("Search term \"%v\" not found\n\n", term)
+			return
+		}
+		fmt.Printf("Found %v results for search term \"%v\" :\n", total, term)
+		if len(results) < total {
+			if len(results) == 0 {
+				fmt.Printf("No results past %v\n\n", offset)
+				return
+			}
+			fmt.Printf("Showing results %v to %v\n", offset+1, offset+len(results))
+		}
+		for _, r := range results {
+			fmt.Printf("%v\n%v\n", r.Title, r.URL)
+			if r.Snippet != "" {
+				fmt.Printf("%v\n", r.Snippet)
+			}
+			if r.Count > 0 {
+				fmt.Printf("Occurences: %v\n", r.Count)
+			}
+			fmt.Printf("\n")
+		}
+	}
+}
+
+func renderCrawlSummary(rooturl string, summary crawlSummary) {
+	switch OutputFormat {
+	case "json":
+		errors := summary.errors
+		if errors == nil {
+			errors = []crawlError{}
+		}
+		rejected := summary.rejected
+		if rejected == nil {
+			rejected = []scopeReject{}
+		}
+		traps := summary.traps
+		if traps == nil {
+			traps = []trapReport{}
+		}
+		writeJSON(struct {
+			URL         string        `json:"url"`
+			Pages       int           `json:"pages"`
+			Terms       int           `json:"terms"`
+			Errors      []crawlError  `json:"errors"`
+			Rejected    []scopeReject `json:"rejected"`
+			Traps       []trapReport  `json:"traps"`
+			StoppedBy   string        `json:"stopped_by"`
+			HostLimited int           `json:"host_limited"`
+			NotIndexed  int           `json:"not_indexed"`
+			Truncated   int           `json:"truncated"`
+			Duplicates  int           `json:"duplicates"`
+		}{rooturl, summary.uniquePages, summary.uniqueTerms, errors, rejected, traps, summary.stoppedBy, summary.hostLimited, summary.notIndexed, summary.truncated, summary.duplicates})
+	case "csv", "tsv":
+		rejected := 0
+		for _, r := range summary.rejected {
+			rejected += r.Rejected
+		}
+		trapped := 0
+		for _, t := range summary.traps {
+			trapped += t.URLs
+		}
+		writeTable([]string{"url", "pages", "terms", "errors", "rejected", "trapped", "stopped_by", "host_limited", "not_indexed", "truncated", "duplicates"}, [][]string{{
+			rooturl, strconv.Itoa(summary.uniquePages), strconv.Itoa(summary.uniqueTerms), strconv.Itoa(len(summary.errors)), strconv.Itoa(rejected), strconv.Itoa(trapped),
+			summary.stoppedBy, strconv.Itoa(summary.hostLimited), strconv.Itoa(summary.notIndexed), strconv.Itoa(summary.truncated),
+			strconv.Itoa(summary.duplicates),
+		}})
+	default:
+		fmt.Printf("Indexed %v pages and %v terms\n", summary.uniquePages, summary.uniqueTerms)
+		if summary.stoppedBy != "" {
+			fmt.Printf("Crawl stopped early by the %v limit\n", summary.stoppedBy)
+		}
+		if summary.notIndexed > 0 {
+			fmt.Printf("%v pages were not indexed because they are of a type that is not parsed or are marked noindex\n", summary.notIndexed)
+		}
+		if summary.truncated > 0 {
+			fmt.Printf("%v pages were cut short by the maxbodysize, maxbytes or maxtokens limits\n", summary.truncated)
+		}
+		if summary.duplicates > 0 {
+			fmt.Printf("%v pages were not indexed as near-duplicates, use the duplicates command for details\n", summary.duplicates)
+		}
+		if summary.hostLimited > 0 {
+			fmt.Printf("%v URLs skipped by the maxhostpages limit\n", summary.hostLimited)
+		}
+		if len(summary.errors) > 0 {
+			fmt.Printf("%v pages could not be retrieved, use the errors command for details\n", len(summary.errors))
+		}
+		for _, r := range summary.rejected {
+			fmt.Printf("\t%v URLs rejected by %v\n", r.Rejected, r.Rule)
+		}
+		if len(summary.traps) > 0 {
+			fmt.Printf("Suspected crawler traps, not crawled:\n")
+			for _, t := range summary.traps {
+				fmt.Printf("\t%v URLs like %v (%v)\n", t.URLs, t.Example, t.Reason)
+			}
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func renderErrors(errors []crawlError) {
+	switch OutputFormat {
+	case "json":
+		if errors == nil {
+			errors = []crawlError{}
+		}
+		writeJSON(errors)
+	case "csv", "tsv":
+		rows := [][]string{}
+		for _, e := range errors {
+			rows = append(rows, []string{e.URL, e.Error, strconv.Itoa(e.Attempts)})
+		}
+		writeTable([]string{"url", "error", "attempts"}, rows)
+	default:
+		if len(errors) == 0 {
+			fmt.Printf("No errors in the last crawl\n\n")
+			return
+		}
+		fmt.Printf("%v errors in the last crawl:\n", len(errors))
+		for _, e := range errors {
+			fmt.Printf("%v\n\t%v (%v attempts)\n", e.URL, e.Error, e.Attempts)
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func renderDuplicates(clusters []duplicateCluster) {
+	switch OutputFormat {
+	case "json":
+		if clusters == nil {
+			clusters = []duplicateCluster{}
+		}
+		writeJSON(clusters)
+	case "csv", "tsv":
+		rows := [][]string{}
+		for _, c := range clusters {
+			for _, d := range c.Duplicates {
+				rows = append(rows, []string{c.URL, d})
+			}
+		}
+		writeTable([]string{"url", "duplicate"}, rows)
+	default:
+		if len(clusters) == 0 {
+			fmt.Printf("No near-duplicate pages found\n\n")
+			return
+		}
+		fmt.Printf("%v pages have near-duplicates:\n", len(clusters))
+		for _, c := range clusters {
+			fmt.Printf("%v\n%v\n", c.Title, c.URL)
+			for _, d := range c.Duplicates {
+				fmt.Printf("\t%v\n", d)
+			}
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func renderConfig(settings []configSetting) {
+	switch OutputFormat {
+	case "json":
+		writeJSON(settings)
+	case "csv", "tsv":
+		rows := [][]string{}
+		for _, s := range settings {
+			rows = append(rows, []string{s.Name, s.Value, s.Source, s.Description})
+		}
+		writeTable([]string{"name", "value", "source", "description"}, rows)
+	default:
+		fmt.Printf("Configuration settings:\n")
+		for _, s := range settings {
+			fmt.Printf("\t%v %v\t(%v)\t%v\n", s.Label, s.Value, s.Source, s.Description)
+		}
+	}
+}
+
+func renderScopeRules(rules []scopeRule) {
+	switch OutputFormat {
+	case "json":
+		type jsonRule struct {
+			Type    string `json:"type"`
+			Pattern string `json:"pattern"`
+		}
+		list := []jsonRule{}
+		for _, r := range rules {
+			list = append(list, jsonRule{strings.Fields(r.String())[0], r.pattern})
+		}
+		writeJSON(list)
+	case "csv", "tsv":
+		rows := [][]string{}
+		for _, r := range rules {
+			rows = append(rows, strings.SplitN(r.String(), " ", 2))
+		}
+		writeTable([]string{"type", "pattern"}, rows)
+	default:
+		if len(rules) == 0 {
+			fmt.Printf("No scope rules, all links within the crawl domain are followed\n\n")
+			return
+		}
+		fmt.Printf("Scope rules:\n")
+		for _, r := range rules {
+			fmt.Printf("\t%v\n", r)
+		}
+		fmt.Printf("\n")
+	}
+}
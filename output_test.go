@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The standard output of f
+func captureOutput(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestSearchCSVTotal(t *testing.T) {
+	format := OutputFormat
+	defer func() { OutputFormat = format }()
+	OutputFormat = "csv"
+	results := []searchResult{{"Penguins", "http://example.com/", 1, 2, ""}}
+	got := captureOutput(t, func() { renderSearchResults("penguins", 7, 0, results) })
+	want := "total,title,url,score,count,snippet\n7,Penguins,http://example.com/,1,2,\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSearchPageNeedsLimit(t *testing.T) {
+	_, index, titles := newTestIndex()
+	index.Add("http://example.com/", map[string]int{"penguins": 1})
+	got := captureOutput(t, func() { Search("-p 2 penguins", index, titles) })
+	if !strings.Contains(got, "needs -n") {
+		t.Errorf("search -p 2 without -n printed %q", got)
+	}
+}
+
+func TestDuplicatesJSONEmpty(t *testing.T) {
+	format := OutputFormat
+	defer func() { OutputFormat = format }()
+	OutputFormat = "json"
+	if got := captureOutput(t, func() { renderDuplicates(nil) }); got != "[]\n" {
+		t.Errorf("got %q, want an empty list", got)
+	}
+}
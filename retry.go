@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Failed fetches are classified as retryable (timeouts, dropped connections,
+// 429 and 5xx gateway responses) or permanent (everything else, including
+// refused connections, since nothing is listening).  Retryable fetches are tried
+// up to RetryAttempts times in all, waiting a jittered exponential backoff
+// between attempts, or the time the server asked for with Retry-After, but never
+// more than RetryMaxWait.  The wait ends early, with the last failure, if the
+// crawl's context is done, and isn't started if it would outlast the context's
+// deadline.
+
+var RetryAttempts = 3
+var RetryBackoff = time.Second
+var RetryMaxWait = 30 * time.Second
+
+// An HTTP response with a status other than 2xx.  Some of these mean the page
+// should be tried again later.
+type statusError struct {
+	code       int
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return e.status
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func newStatusError(resp *http.Response) *statusError {
+	e := &statusError{code: resp.StatusCode, status: fmt.Sprintf("%v %v", resp.StatusCode, http.StatusText(resp.StatusCode))}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return e
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+func retryableError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return retryableStatus(se.code)
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// How long to wait before the next attempt, after attempt attempts have failed
+// with err.  Returns false if err is permanent.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	if !retryableError(err) {
+		return 0, false
+	}
+	var se *statusError
+	if errors.As(err, &se) && se.retryAfter > 0 {
+		if se.retryAfter > RetryMaxWait {
+			return RetryMaxWait, true
+		}
+		return se.retryAfter, true
+	}
+	backoff := RetryBackoff << uint(attempt-1)
+	if backoff > RetryMaxWait || backoff <= 0 {
+		backoff = RetryMaxWait
+	}
+	// wait somewhere between half and all of the backoff so retries spread out
+	if half := int64(backoff / 2); half > 0 {
+		backoff = time.Duration(half + rand.Int63n(half+1))
+	}
+	return backoff, true
+}
+
+// Wait before retrying, returning false if ctx is done first or its deadline
+// would pass while waiting
+func retryWait(ctx context.Context, wait time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
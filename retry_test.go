@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// Nothing listening means nothing to retry
+func TestConnectionRefusedNotRetried(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	results := GetURL("http://" + addr + "/")
+	if results.Err == nil || results.Attempts != 1 {
+		t.Errorf("got error %v after %v attempts, want a failure after 1", results.Err, results.Attempts)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	if !retryWait(context.Background(), time.Millisecond) {
+		t.Errorf("wait with no deadline ended early")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if retryWait(ctx, time.Minute) || time.Since(start) > time.Second {
+		t.Errorf("wait past the deadline wasn't given up on at once")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start = time.Now()
+	if retryWait(ctx, time.Minute) || time.Since(start) > time.Second {
+		t.Errorf("wait wasn't ended by cancelling the context")
+	}
+}
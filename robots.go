@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Page level robots directives, from <meta name="robots"> tags and X-Robots-Tag
+// headers.  noindex pages are crawled but not indexed, nofollow pages don't
+// contribute their links, and none means both.
+
+type robotsDirectives struct {
+	noIndex, noFollow bool
+}
+
+// The name this crawler answers to in agent specific directives, e.g. searcher
+// for a User-Agent of searcher/1.0.0
+func robotsAgent() string {
+	agent := strings.ToLower(strings.TrimSpace(UserAgent))
+	if i := strings.IndexAny(agent, "/ "); i >= 0 {
+		agent = agent[:i]
+	}
+	return agent
+}
+
+// Add the directives in a meta robots content attribute or X-Robots-Tag value.
+// A value may start with an agent name, as in "otherbot: noindex", in which case
+// it only applies if the agent is this crawler.
+func (rd *robotsDirectives) Parse(value string) {
+	value = strings.ToLower(value)
+	if agent, rest, ok := strings.Cut(value, ":"); ok && !strings.Contains(agent, ",") {
+		agent = strings.TrimSpace(agent)
+		switch agent {
+		case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+			// a directive with a value, not an agent
+		default:
+			if agent != robotsAgent() {
+				return
+			}
+			value = rest
+		}
+	}
+	for _, directive := range strings.Split(value, ",") {
+		switch strings.TrimSpace(directive) {
+		case "noindex":
+			rd.noIndex = true
+		case "nofollow":
+			rd.noFollow = true
+		case "none":
+			rd.noIndex = true
+			rd.noFollow = true
+		}
+	}
+}
+
+// Whether a meta tag name holds robots directives for this crawler
+func isRobotsMeta(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name == "robots" || name == robotsAgent()
+}
+
+// The registrable domain (eTLD+1) of host, using the public suffix list.  Hosts
+// that have none, such as localhost or an IP address, are returned unchanged.
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// Resolve and normalize the href of a <link rel="canonical"> against the page url.  Returns ""
+// if it isn't a usable http(s) url within the registrable domain of the page, so
+// one site can't claim to be another.
+func resolveCanonical(base, href string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	canonical := baseURL.ResolveReference(ref)
+	canonical.Fragment = ""
+	if canonical.Scheme != "http" && canonical.Scheme != "https" {
+		return ""
+	}
+	if registrableDomain(canonical.Hostname()) != registrableDomain(baseURL.Hostname()) {
+		return ""
+	}
+	return normalizeURL(canonical.String())
+}
+
+// Whether a rel attribute, which may hold several space separated values, includes value
+func hasRel(rel, value string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRobotsDirectives(t *testing.T) {
+	agent := UserAgent
+	defer func() { UserAgent = agent }()
+	UserAgent = "Searcher/1.0.0 (+https://example.com/bot)"
+	tests := []struct {
+		values            []string
+		noIndex, noFollow bool
+	}{
+		{[]string{"noindex"}, true, false},
+		{[]string{"NoFollow"}, false, true},
+		{[]string{"noindex, nofollow"}, true, true},
+		{[]string{"none"}, true, true},
+		{[]string{"all"}, false, false},
+		{[]string{"index, follow, max-snippet:20"}, false, false},
+		{[]string{"unavailable_after: 2030-01-01, noindex"}, true, false},
+		// agent prefixes only apply to the agent named
+		{[]string{"searcher: noindex"}, true, false},
+		{[]string{"SEARCHER: none"}, true, true},
+		{[]string{"otherbot: noindex"}, false, false},
+		{[]string{"otherbot: noindex", "nofollow"}, false, true},
+	}
+	for _, test := range tests {
+		var rd robotsDirectives
+		for _, value := range test.values {
+			rd.Parse(value)
+		}
+		if rd.noIndex != test.noIndex || rd.noFollow != test.noFollow {
+			t.Errorf("%q gave noindex %v nofollow %v, want %v and %v", test.values, rd.noIndex, rd.noFollow, test.noIndex, test.noFollow)
+		}
+	}
+	for name, want := range map[string]bool{"robots": true, "ROBOTS": true, "searcher": true, "otherbot": false, "description": false} {
+		if got := isRobotsMeta(name); got != want {
+			t.Errorf("isRobotsMeta(%v) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestResolveCanonical(t *testing.T) {
+	tests := []struct {
+		base, href, want string
+	}{
+		{"http://www.example.com/a/page.html?s=1", "/a/page.html", "http://www.example.com/a/page.html"},
+		{"http://www.example.com/a/page.html", "other.html#top", "http://www.example.com/a/other.html"},
+		{"http://www.example.com/a/", "https://example.com/a/", "https://example.com/a/"},
+		{"http://www.example.com/a/", "http://blog.example.com/a/", "http://blog.example.com/a/"},
+		// another registrable domain can't be claimed
+		{"http://www.example.com/a/", "http://www.example.org/a/", ""},
+		{"http://alice.github.io/", "http://bob.github.io/", ""},
+		{"http://www.bbc.co.uk/", "http://www.itv.co.uk/", ""},
+		{"http://localhost:8080/", "http://127.0.0.1:8080/", ""},
+		{"http://www.example.com/a/", "mailto:web@example.com", ""},
+	}
+	for _, test := range tests {
+		if got := resolveCanonical(test.base, test.href); got != test.want {
+			t.Errorf("canonical %v on %v resolved to %q, want %q", test.href, test.base, got, test.want)
+		}
+	}
+}
+
+// Meta robots tags and X-Robots-Tag headers keep pages out of the index and
+// their links out of the crawl
+func TestGetURLRobots(t *testing.T) {
+	mux := http.NewServeMux()
+	page := func(head string) string {
+		return `<html><head><title>Page</title>` + head + `</head><body><p>penguins</p><a href="/next.html">next</a></body></html>`
+	}
+	mux.HandleFunc("/meta-noindex.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page(`<meta name="robots" content="noindex">`))
+	})
+	mux.HandleFunc("/meta-agent.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page(`<meta name="searcher" content="nofollow"><meta name="otherbot" content="noindex">`))
+	})
+	mux.HandleFunc("/header-none.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "searcher: none")
+		fmt.Fprint(w, page(""))
+	})
+	mux.HandleFunc("/header-other.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "otherbot: none")
+		fmt.Fprint(w, page(`<link rel="canonical" href="/canonical.html">`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path              string
+		noIndex, noFollow bool
+		canonical         string
+	}{
+		{"/meta-noindex.html", true, false, ""},
+		{"/meta-agent.html", false, true, ""},
+		{"/header-none.html", true, true, ""},
+		{"/header-other.html", false, false, server.URL + "/canonical.html"},
+	}
+	for _, test := range tests {
+		results := GetURL(server.URL + test.path)
+		if results.Err != nil {
+			t.Fatalf("GetURL(%v) failed: %v", test.path, results.Err)
+		}
+		if results.NoIndex != test.noIndex || results.NoFollow != test.noFollow || results.Canonical != test.canonical {
+			t.Errorf("%v got noindex %v, nofollow %v and canonical %q, want %v, %v and %q", test.path, results.NoIndex, results.NoFollow, results.Canonical, test.noIndex, test.noFollow, test.canonical)
+		}
+		if indexed := results.Index["penguins"] > 0; indexed == test.noIndex {
+			t.Errorf("%v indexed %v with noindex %v", test.path, indexed, test.noIndex)
+		}
+		if followed := len(results.EmbeddedURL) > 0; followed == test.noFollow {
+			t.Errorf("%v has links %v with nofollow %v", test.path, results.EmbeddedURL, test.noFollow)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// The domain policy decides which hosts embedded links may be followed to:
+//
+//	host	only the host of the root url
+//	domain	the registrable domain of the root url and its subdomains, so
+//		www.example.com, blog.example.com and example.com are all followed
+//	list	the registrable domain of the root url and the AllowedDomains,
+//		each including its subdomains
+//	any	every host
+
+var DomainPolicy = "domain"
+var AllowedDomains []string
+
+var domainPolicies = []string{"host", "domain", "list", "any"}
+
+// The kinds of tag links are followed from:
+//
+//	a	<a href>
+//	area	<area href> in image maps
+//	frame	<frame src>
+//	iframe	<iframe src>
+//	link	<link rel="next"> and <link rel="prev">
+//	refresh	<meta http-equiv="refresh" content="0; url=...">
+
+var LinkSources = []string{"a", "area", "frame", "iframe", "link", "refresh"}
+
+var linkSourceNames = []string{"a", "area", "frame", "iframe", "link", "refresh"}
+
+func isLinkSource(name string) bool {
+	for _, s := range linkSourceNames {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func linkSourceEnabled(source string) bool {
+	for _, s := range LinkSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether host is domain or one of its subdomains
+func inDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+type domainScope struct {
+	policy   string
+	rootHost string
+	domains  []string
+}
+
+func newDomainScope(root *url.URL) domainScope {
+	ds := domainScope{policy: DomainPolicy, rootHost: strings.ToLower(strings.TrimSuffix(root.Hostname(), "."))}
+	if ds.policy == "domain" || ds.policy == "list" {
+		// a root with no registrable domain, such as localhost, an IP address
+		// or a public suffix like co.uk, only lets in its own host
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(ds.rootHost); err == nil {
+			ds.domains = []string{domain}
+		}
+	}
+	if ds.policy == "list" {
+		for _, d := range AllowedDomains {
+			ds.domains = append(ds.domains, strings.ToLower(strings.TrimPrefix(d, ".")))
+		}
+	}
+	return ds
+}
+
+func (ds domainScope) Allow(u *url.URL) bool {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	switch ds.policy {
+	case "any":
+		return true
+	case "host":
+		return host == ds.rootHost
+	}
+	if host == ds.rootHost {
+		return true
+	}
+	for _, d := range ds.domains {
+		if inDomain(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Include and exclude rules limiting which embedded links are crawled.
+//
+// A pattern starting with re: is a regular expression, anything else is a glob
+// where * matches any run of characters and ? a single character.  Patterns
+// starting with / (after any re:) are matched against the path and query of the
+// URL, all others against the whole URL.
+//
+// A URL is crawled when it matches no exclude rule and, if there are include
+// rules, it matches at least one of them.
+
+type scopeRule struct {
+	include bool
+	pattern string
+	re      *regexp.Regexp
+	onPath  bool
+}
+
+func (r scopeRule) String() string {
+	if r.include {
+		return "include " + r.pattern
+	}
+	return "exclude " + r.pattern
+}
+
+func (r scopeRule) matches(u *url.URL) bool {
+	if r.onPath {
+		target := u.EscapedPath()
+		if u.RawQuery != "" {
+			target += "?" + u.RawQuery
+		}
+		return r.re.MatchString(target)
+	}
+	return r.re.MatchString(u.String())
+}
+
+func newScopeRule(include bool, pattern string) (scopeRule, error) {
+	expr := ""
+	if strings.HasPrefix(pattern, "re:") {
+		expr = strings.TrimPrefix(pattern, "re:")
+	} else {
+		expr = "^" + globToRegexp(pattern) + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return scopeRule{}, err
+	}
+	onPath := strings.HasPrefix(strings.TrimPrefix(expr, "^"), "/")
+	return scopeRule{include, pattern, re, onPath}, nil
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// The rules managed by the scope command
+var ScopeRules []scopeRule
+
+// The number of URLs a rule kept out of a crawl
+type scopeReject struct {
+	Rule     string `json:"rule"`
+	Rejected int    `json:"rejected"`
+}
+
+// Used when a URL is rejected because it matches none of the include rules
+const notIncluded = "include rules"
+
+// Used when a URL is rejected because it was only found in tags not in LinkSources
+const disabledSources = "linksources"
+
+// The most rejected urls remembered, so that a url linked from many pages is
+// only counted once.  Past this the oldest are forgotten, and may be counted again.
+var scopeSeenLimit = 100000
+
+// The domain policy and scope rules in force for one crawl, counting the unique
+// URLs each rejected
+type crawlScope struct {
+	domain    domainScope
+	rules     []scopeRule
+	rejected  map[string]int // the urls each rule rejected
+	seen      map[string]bool
+	seenOrder []string // the urls in seen, oldest at seenNext once full
+	seenNext  int
+	mux       sync.Mutex
+}
+
+func newCrawlScope(root *url.URL, rules []scopeRule) *crawlScope {
+	return &crawlScope{domain: newDomainScope(root), rules: append([]scopeRule(nil), rules...), rejected: make(map[string]int),
+		seen: make(map[string]bool)}
+}
+
+func (cs *crawlScope) reject(u *url.URL, rule string) bool {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	if !cs.seen[u.String()] {
+		cs.rejected[rule]++
+		cs.remember(u.String())
+	}
+	return false
+}
+
+// Remember a rejected url, forgetting the oldest url once scopeSeenLimit are
+// remembered
+func (cs *crawlScope) remember(u string) {
+	if scopeSeenLimit > 0 && len(cs.seenOrder) >= scopeSeenLimit {
+		delete(cs.seen, cs.seenOrder[cs.seenNext])
+		cs.seenOrder[cs.seenNext] = u
+		cs.seenNext = (cs.seenNext + 1) % len(cs.seenOrder)
+	} else {
+		cs.seenOrder = append(cs.seenOrder, u)
+	}
+	cs.seen[u] = true
+}
+
+// Check whether u should be crawled, recording the rule that rejected it if not
+func (cs *crawlScope) Allow(u *url.URL) bool {
+	if !cs.domain.Allow(u) {
+		return cs.reject(u, "domain policy "+cs.domain.policy)
+	}
+	if u.Scheme == "file" {
+		if filter := localFileFilter(u); filter != "" {
+			return cs.reject(u, filter)
+		}
+	}
+	if len(cs.rules) == 0 {
+		return true
+	}
+	rejectedBy := ""
+	hasInclude, included := false, false
+	for _, r := range cs.rules {
+		if r.include {
+			hasInclude = true
+			if r.matches(u) {
+				included = true
+			}
+		} else if rejectedBy == "" && r.matches(u) {
+			rejectedBy = r.String()
+		}
+	}
+	if rejectedBy == "" && hasInclude && !included {
+		rejectedBy = notIncluded
+	}
+	if rejectedBy == "" {
+		return true
+	}
+	return cs.reject(u, rejectedBy)
+}
+
+// Check whether a link found in the given kinds of tag should be followed,
+// recording it as rejected if none of them are in LinkSources
+func (cs *crawlScope) AllowSources(u *url.URL, sources []string) bool {
+	for _, s := range sources {
+		if linkSourceEnabled(s) {
+			return true
+		}
+	}
+	return cs.reject(u, disabledSources)
+}
+
+// The scope's counts, saved in checkpoints
+type scopeCounts struct {
+	Rejected map[string]int
+	SeenURLs []string // oldest first
+}
+
+func (cs *crawlScope) Counts() scopeCounts {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	counts := scopeCounts{Rejected: make(map[string]int)}
+	for k, v := range cs.rejected {
+		counts.Rejected[k] = v
+	}
+	for i := range cs.seenOrder {
+		counts.SeenURLs = append(counts.SeenURLs, cs.seenOrder[(cs.seenNext+i)%len(cs.seenOrder)])
+	}
+	return counts
+}
+
+// Carry on from the counts of the crawl being resumed
+func (cs *crawlScope) Restore(counts scopeCounts) {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	for k, v := range counts.Rejected {
+		cs.rejected[k] = v
+	}
+	for _, u := range counts.SeenURLs {
+		cs.remember(u)
+	}
+}
+
+// How many URLs each rule rejected, in rule order
+func (cs *crawlScope) Rejects() []scopeReject {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	counts := cs.rejected
+	var rejects []scopeReject
+	domainRule := "domain policy " + cs.domain.policy
+	if n, ok := counts[domainRule]; ok {
+		rejects = append(rejects, scopeReject{domainRule, n})
+	}
+	if n, ok := counts[disabledSources]; ok {
+		rejects = append(rejects, scopeReject{disabledSources, n})
+	}
+	// the file filters, in the order they are checked
+	var fileRules []string
+	for _, pattern := range FileExclude {
+		fileRules = append(fileRules, "fileexclude "+pattern)
+	}
+	for _, rule := range append(fileRules, "fileinclude", "maxfilesize") {
+		if n, ok := counts[rule]; ok {
+			rejects = append(rejects, scopeReject{rule, n})
+		}
+	}
+	for _, r := range cs.rules {
+		if !r.include {
+			rejects = append(rejects, scopeReject{r.String(), counts[r.String()]})
+		}
+	}
+	if n, ok := counts[notIncluded]; ok {
+		rejects = append(rejects, scopeReject{notIncluded, n})
+	}
+	return rejects
+}
+
+// The scope command: scope add include|exclude (pattern), scope remove (pattern), scope list
+func Scope(command string) {
+	commandArgs := strings.Fields(command)
+	if len(commandArgs) == 0 {
+		commandArgs = []string{"list"}
+	}
+	switch commandArgs[0] {
+	case "add":
+		if len(commandArgs) != 3 || (commandArgs[1] != "include" && commandArgs[1] != "exclude") {
+			fmt.Printf("scope add needs include or exclude and a pattern\n")
+			return
+		}
+		rule, err := newScopeRule(commandArgs[1] == "include", commandArgs[2])
+		if err != nil {
+			fmt.Printf("Bad pattern %v: %v\n", commandArgs[2], err)
+			return
+		}
+		ScopeRules = append(ScopeRules, rule)
+		progressf("Added scope rule %v\n", rule)
+	case "remove":
+		if len(commandArgs) != 2 {
+			fmt.Printf("scope remove needs the pattern to remove\n")
+			return
+		}
+		for i, r := range ScopeRules {
+			if r.pattern == commandArgs[1] {
+				ScopeRules = append(ScopeRules[:i], ScopeRules[i+1:]...)
+				progressf("Removed scope rule %v\n", r)
+				return
+			}
+		}
+		fmt.Printf("No scope rule %v\n", commandArgs[1])
+	case "list":
+		renderScopeRules(ScopeRules)
+	default:
+		fmt.Printf("scope command needs add, remove or list\n")
+	}
+}
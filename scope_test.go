@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func testScope(t *testing.T, patterns ...string) *crawlScope {
+	var rules []scopeRule
+	for i := 0; i < len(patterns); i += 2 {
+		rule, err := newScopeRule(patterns[i] == "include", patterns[i+1])
+		if err != nil {
+			t.Fatalf("newScopeRule(%v) failed: %v", patterns[i+1], err)
+		}
+		rules = append(rules, rule)
+	}
+	root, _ := url.Parse("http://example.com/")
+	return newCrawlScope(root, rules)
+}
+
+func allowURL(cs *crawlScope, rawurl string) bool {
+	u, _ := url.Parse(rawurl)
+	return cs.Allow(u)
+}
+
+func TestScopeRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern, url string
+		want         bool
+	}{
+		// globs match the whole url, or with a leading / the path and query
+		{"*/blog/*", "http://example.com/blog/post.html", true},
+		{"*/blog/*", "http://example.com/news/post.html", false},
+		{"/blog/*", "http://example.com/blog/post.html", true},
+		{"/blog/*", "http://example.com/other/blog/post.html", false},
+		{"/search?q=*", "http://example.com/search?q=walrus", true},
+		{"/page?.html", "http://example.com/page2.html", true},
+		{"/page?.html", "http://example.com/page10.html", false},
+		{"http://example.com/*", "http://example.com/a/b", true},
+		{"http://example.com/*", "https://example.com/a/b", false},
+		// glob characters other than * and ? are literal
+		{"/a.html", "http://example.com/aXhtml", false},
+		// re: patterns are unanchored regular expressions
+		{"re:/[0-9]{4}/", "http://example.com/archive/2024/05", true},
+		{"re:/[0-9]{4}/", "http://example.com/archive/24/05", false},
+		{"re:^/archive/", "http://example.com/archive/2024", true},
+		{"re:^/archive/", "http://example.com/x/archive/2024", false},
+		{"re:\\.pdf$", "http://example.com/doc.pdf", true},
+	}
+	for _, test := range tests {
+		rule, err := newScopeRule(true, test.pattern)
+		if err != nil {
+			t.Fatalf("newScopeRule(%v) failed: %v", test.pattern, err)
+		}
+		u, _ := url.Parse(test.url)
+		if got := rule.matches(u); got != test.want {
+			t.Errorf("%v matched %v: %v, want %v", test.pattern, test.url, got, test.want)
+		}
+	}
+}
+
+func TestScopeBadRegexp(t *testing.T) {
+	if _, err := newScopeRule(false, "re:(unclosed"); err == nil {
+		t.Errorf("re:(unclosed compiled, want an error")
+	}
+}
+
+// A url is crawled if it matches no exclude rule and, when there are include
+// rules, one of them.  Each rejected url is counted once against the first
+// exclude rule it matches, or against the include rules.
+func TestScopeIncludeExclude(t *testing.T) {
+	cs := testScope(t, "include", "/docs/*", "include", "/blog/*", "exclude", "/docs/old/*", "exclude", "*.pdf")
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://example.com/docs/guide.html", true},
+		{"http://example.com/blog/post.html", true},
+		{"http://example.com/docs/old/guide.html", false},
+		{"http://example.com/docs/old/guide.pdf", false},
+		{"http://example.com/blog/post.pdf", false},
+		{"http://example.com/about.html", false},
+		{"http://example.com/about.html", false},
+	}
+	for _, test := range tests {
+		if got := allowURL(cs, test.url); got != test.want {
+			t.Errorf("%v allowed %v, want %v", test.url, got, test.want)
+		}
+	}
+	want := []scopeReject{{"exclude /docs/old/*", 2}, {"exclude *.pdf", 1}, {notIncluded, 1}}
+	if got := cs.Rejects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rejects %v, want %v", got, want)
+	}
+}
+
+func TestScopeWithoutRules(t *testing.T) {
+	cs := testScope(t)
+	if !allowURL(cs, "http://example.com/anything") || len(cs.Rejects()) != 0 {
+		t.Errorf("no rules rejected a url")
+	}
+	cs = testScope(t, "exclude", "/private/*")
+	if !allowURL(cs, "http://example.com/public/") || allowURL(cs, "http://example.com/private/x") {
+		t.Errorf("only exclude rules should allow everything else")
+	}
+}
+
+func TestDomainPolicy(t *testing.T) {
+	policy, allowed := DomainPolicy, AllowedDomains
+	defer func() { DomainPolicy, AllowedDomains = policy, allowed }()
+	tests := []struct {
+		policy  string
+		allowed []string
+		root    string
+		url     string
+		want    bool
+	}{
+		{"host", nil, "http://www.example.com/", "http://www.example.com/a", true},
+		{"host", nil, "http://www.example.com/", "http://example.com/a", false},
+		{"host", nil, "http://www.example.com/", "http://WWW.Example.com:8080/a", true},
+		{"domain", nil, "http://www.example.com/", "http://example.com/a", true},
+		{"domain", nil, "http://www.example.com/", "http://blog.example.com/a", true},
+		{"domain", nil, "http://www.example.com/", "http://notexample.com/a", false},
+		{"domain", nil, "http://www.example.com/", "http://example.com.evil.org/a", false},
+		// the registrable domain is one label below the public suffix
+		{"domain", nil, "http://www.bbc.co.uk/", "http://news.bbc.co.uk/", true},
+		{"domain", nil, "http://www.bbc.co.uk/", "http://www.itv.co.uk/", false},
+		{"domain", nil, "http://alice.github.io/", "http://alice.github.io/blog", true},
+		{"domain", nil, "http://alice.github.io/", "http://bob.github.io/", false},
+		{"domain", nil, "http://example.com./", "http://www.example.com/", true},
+		// hosts with no registrable domain only match themselves
+		{"domain", nil, "http://localhost:8080/", "http://localhost:9090/", true},
+		{"domain", nil, "http://127.0.0.1/", "http://127.0.0.2/", false},
+		{"domain", nil, "http://co.uk/", "http://bbc.co.uk/", false},
+		{"list", []string{"example.org", ".Example.NET"}, "http://www.example.com/", "http://blog.example.com/", true},
+		{"list", []string{"example.org", ".Example.NET"}, "http://www.example.com/", "http://docs.example.org/", true},
+		{"list", []string{"example.org", ".Example.NET"}, "http://www.example.com/", "http://example.net/", true},
+		{"list", []string{"example.org", ".Example.NET"}, "http://www.example.com/", "http://example.info/", false},
+		{"list", nil, "http://www.example.com/", "http://example.org/", false},
+		{"any", nil, "http://www.example.com/", "http://anywhere.org/", true},
+	}
+	for _, test := range tests {
+		DomainPolicy, AllowedDomains = test.policy, test.allowed
+		root, _ := url.Parse(test.root)
+		cs := newCrawlScope(root, nil)
+		if got := allowURL(cs, test.url); got != test.want {
+			t.Errorf("%v policy %v from %v allowed %v, want %v", test.policy, test.url, test.root, got, test.want)
+		}
+	}
+}
+
+// Domain policy rejections are counted ahead of the scope rules
+func TestDomainPolicyRejects(t *testing.T) {
+	policy := DomainPolicy
+	defer func() { DomainPolicy = policy }()
+	DomainPolicy = "domain"
+	cs := testScope(t, "exclude", "/private/*")
+	for _, u := range []string{"http://other.org/", "http://other.org/private/x", "http://example.com/private/x", "http://example.com/"} {
+		allowURL(cs, u)
+	}
+	want := []scopeReject{{"domain policy domain", 2}, {"exclude /private/*", 1}}
+	if got := cs.Rejects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rejects %v, want %v", got, want)
+	}
+}
+
+// Rejected urls are counted once per rule, remembering only the last
+// scopeSeenLimit, and a checkpoint restores them oldest first
+func TestScopeRejectsBounded(t *testing.T) {
+	limit := scopeSeenLimit
+	defer func() { scopeSeenLimit = limit }()
+	scopeSeenLimit = 10
+
+	cs := testScope(t, "exclude", "/private/*")
+	for i := 0; i < 100; i++ {
+		allowURL(cs, fmt.Sprintf("http://example.com/private/%v", i))
+	}
+	allowURL(cs, "http://example.com/private/99")
+	if len(cs.seen) != 10 || len(cs.seenOrder) != 10 {
+		t.Errorf("remembered %v urls, want 10", len(cs.seen))
+	}
+	want := []scopeReject{{"exclude /private/*", 100}}
+	if got := cs.Rejects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rejects %v, want %v", got, want)
+	}
+
+	counts := cs.Counts()
+	if len(counts.SeenURLs) != 10 || counts.SeenURLs[0] != "http://example.com/private/90" {
+		t.Fatalf("checkpoint remembers %v", counts.SeenURLs)
+	}
+	restored := testScope(t, "exclude", "/private/*")
+	restored.Restore(counts)
+	allowURL(restored, "http://example.com/private/90")
+	if got := restored.Rejects(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored scope got rejects %v after a remembered url, want %v", got, want)
+	}
+	allowURL(restored, "http://example.com/private/100")
+	if restored.seen["http://example.com/private/90"] || len(restored.seen) != 10 {
+		t.Errorf("restored scope remembers %v urls, the oldest not forgotten first", len(restored.seen))
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Crawler trap heuristics.  Calendars, faceted search and relative links that
+// keep extending the path can generate endless unique URLs on one site.  Links
+// that look like such a trap are reported with the crawl summary instead of
+// being queued, grouped by the check that caught them and the section of the
+// site they are in:
+//
+//	maxpathdepth		more path segments than this
+//	maxsegmentrepeats	the same path segment more times than this, e.g. /a/b/a/b/a/b
+//	maxquerylength		a longer query string than this
+//	maxtemplateurls		more URLs than this sharing one path template, where a
+//				template is the url with its numbers and query values
+//				taken out, e.g. example.com/calendar/{n}/{n}?view
+//
+// 0 turns a check off.
+
+var MaxPathDepth = 16
+var MaxSegmentRepeats = 3
+var MaxQueryLength = 256
+var MaxTemplateURLs = 500
+
+// The most urls whose verdict is remembered, so that a url linked from many
+// pages is only counted once.  Past this the oldest are forgotten rather than
+// keep every url of an endless trap, and may be counted again.
+var trapSeenLimit = 100000
+
+// One suspected trap: the check that caught it and the template of its URLs,
+// or for the checks on the shape of the url the site section, e.g. example.com/cal/*
+type trapReport struct {
+	Reason   string `json:"reason"`
+	Template string `json:"template"`
+	URLs     int    `json:"urls"`
+	Example  string `json:"example"`
+}
+
+type crawlTraps struct {
+	templates map[string]int
+	seen      map[string]bool
+	seenOrder []string // the urls in seen, oldest at seenNext once full
+	seenNext  int
+	trapped   map[string]*trapReport
+	mux       sync.Mutex
+}
+
+func newCrawlTraps() *crawlTraps {
+	return &crawlTraps{templates: make(map[string]int), seen: make(map[string]bool), trapped: make(map[string]*trapReport)}
+}
+
+// A remembered url and whether it was allowed
+type seenURL struct {
+	URL     string
+	Allowed bool
+}
+
+// The trap checks' counts, saved in checkpoints
+type trapCounts struct {
+	Templates map[string]int
+	SeenURLs  []seenURL // oldest first
+	Trapped   map[string]trapReport
+}
+
+func (ct *crawlTraps) Counts() trapCounts {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	counts := trapCounts{Templates: make(map[string]int), Trapped: make(map[string]trapReport)}
+	for k, v := range ct.templates {
+		counts.Templates[k] = v
+	}
+	for i := range ct.seenOrder {
+		u := ct.seenOrder[(ct.seenNext+i)%len(ct.seenOrder)]
+		counts.SeenURLs = append(counts.SeenURLs, seenURL{u, ct.seen[u]})
+	}
+	for k, v := range ct.trapped {
+		counts.Trapped[k] = *v
+	}
+	return counts
+}
+
+// Carry on from the counts of the crawl being resumed
+func (ct *crawlTraps) Restore(counts trapCounts) {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	for k, v := range counts.Templates {
+		ct.templates[k] = v
+	}
+	for _, s := range counts.SeenURLs {
+		ct.remember(s.URL, s.Allowed)
+	}
+	for k, v := range counts.Trapped {
+		report := v
+		ct.trapped[k] = &report
+	}
+}
+
+var digitRun = regexp.MustCompile(`[0-9]+`)
+
+// The url with runs of digits in its path replaced by {n} and only the sorted
+// names of its query parameters
+func pathTemplate(u *url.URL) string {
+	template := strings.ToLower(u.Host) + digitRun.ReplaceAllString(u.EscapedPath(), "{n}")
+	if u.RawQuery != "" {
+		var names []string
+		for name := range u.Query() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		template += "?" + strings.Join(names, "&")
+	}
+	return template
+}
+
+// The host and first path segment of a url, which is how traps caught by the
+// checks on the shape of the url are grouped, since every url in them differs
+func siteSection(u *url.URL) string {
+	first, _, _ := strings.Cut(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	return strings.ToLower(u.Host) + "/" + first + "*"
+}
+
+// The check a url fails, if any, not counting the template limit
+func trapCheck(u *url.URL) string {
+	var segments []string
+	for _, s := range strings.Split(u.EscapedPath(), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if MaxPathDepth > 0 && len(segments) > MaxPathDepth {
+		return "maxpathdepth"
+	}
+	if MaxSegmentRepeats > 0 {
+		repeats := make(map[string]int)
+		for _, s := range segments {
+			repeats[s]++
+			if repeats[s] > MaxSegmentRepeats {
+				return "maxsegmentrepeats"
+			}
+		}
+	}
+	if MaxQueryLength > 0 && len(u.RawQuery) > MaxQueryLength {
+		return "maxquerylength"
+	}
+	return ""
+}
+
+func (ct *crawlTraps) trap(u *url.URL, reason, template string) bool {
+	key := reason + " " + template
+	report, ok := ct.trapped[key]
+	if !ok {
+		report = &trapReport{Reason: reason, Template: template, Example: u.String()}
+		ct.trapped[key] = report
+	}
+	report.URLs++
+	ct.remember(u.String(), false)
+	return false
+}
+
+// Remember whether a url was allowed, forgetting the oldest url once
+// trapSeenLimit are remembered
+func (ct *crawlTraps) remember(u string, allowed bool) {
+	if _, ok := ct.seen[u]; ok {
+		ct.seen[u] = allowed
+		return
+	}
+	if trapSeenLimit > 0 && len(ct.seenOrder) >= trapSeenLimit {
+		delete(ct.seen, ct.seenOrder[ct.seenNext])
+		ct.seenOrder[ct.seenNext] = u
+		ct.seenNext = (ct.seenNext + 1) % len(ct.seenOrder)
+	} else {
+		ct.seenOrder = append(ct.seenOrder, u)
+	}
+	ct.seen[u] = allowed
+}
+
+// Check whether u looks like part of a crawler trap, recording it if so
+func (ct *crawlTraps) Allow(u *url.URL) bool {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	if allowed, ok := ct.seen[u.String()]; ok {
+		return allowed
+	}
+	if reason := trapCheck(u); reason != "" {
+		return ct.trap(u, reason, siteSection(u))
+	}
+	template := pathTemplate(u)
+	if MaxTemplateURLs > 0 && ct.templates[template] >= MaxTemplateURLs {
+		return ct.trap(u, "maxtemplateurls", template)
+	}
+	ct.templates[template]++
+	ct.remember(u.String(), true)
+	return true
+}
+
+// The suspected traps, most URLs first
+func (ct *crawlTraps) Traps() []trapReport {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	var traps []trapReport
+	for _, report := range ct.trapped {
+		traps = append(traps, *report)
+	}
+	sort.Slice(traps, func(i, j int) bool {
+		if traps[i].URLs != traps[j].URLs {
+			return traps[i].URLs > traps[j].URLs
+		}
+		return traps[i].Reason+traps[i].Template < traps[j].Reason+traps[j].Template
+	})
+	return traps
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+// An endless trap only remembers the last trapSeenLimit urls, and a checkpoint
+// restores them oldest first
+func TestTrapSeenURLsBounded(t *testing.T) {
+	limit, depth := trapSeenLimit, MaxPathDepth
+	defer func() { trapSeenLimit, MaxPathDepth = limit, depth }()
+	trapSeenLimit, MaxPathDepth = 10, 2
+
+	traps := newCrawlTraps()
+	allow := func(ct *crawlTraps, i int) bool {
+		u, _ := url.Parse(fmt.Sprintf("http://example.com/cal/%v/day", i))
+		return ct.Allow(u)
+	}
+	for i := 0; i < 100; i++ {
+		if allow(traps, i) {
+			t.Fatalf("url %v allowed, want trapped by maxpathdepth", i)
+		}
+	}
+	allow(traps, 99)
+	if len(traps.seen) != 10 || len(traps.seenOrder) != 10 {
+		t.Errorf("remembered %v urls, want 10", len(traps.seen))
+	}
+	if got := traps.Traps(); len(got) != 1 || got[0].URLs != 100 {
+		t.Errorf("got traps %+v, want one of 100 urls", got)
+	}
+
+	counts := traps.Counts()
+	if len(counts.SeenURLs) != 10 || counts.SeenURLs[0].URL != "http://example.com/cal/90/day" {
+		t.Fatalf("checkpoint remembers %v", counts.SeenURLs)
+	}
+	restored := newCrawlTraps()
+	restored.Restore(counts)
+	allow(restored, 90)
+	if got := restored.Traps(); got[0].URLs != 100 {
+		t.Errorf("restored trap counted %v urls after a remembered one, want 100", got[0].URLs)
+	}
+	allow(restored, 100)
+	if _, ok := restored.seen["http://example.com/cal/90/day"]; ok || len(restored.seen) != 10 {
+		t.Errorf("restored traps remember %v urls, the oldest not forgotten first", len(restored.seen))
+	}
+}
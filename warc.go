@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WARC archives.  While WARCDir is set, every HTTP request a crawl makes,
+// including redirects and retries, is written with its response to WARC 1.1
+// files in that directory, each record gzip-compressed on its own as other WARC
+// tools expect.  A crawl starts a new file, named for the time it started, and
+// moves on to another once a file grows past WARCMaxSize.  Response bodies are
+// archived up to MaxBodySize, and marked truncated beyond that.  The part of a
+// body the crawl didn't read, such as the page of an error response, is read
+// for the archive only up to warcUnreadSize.
+//
+// The importwarc command builds the index from WARC files, compressed or not,
+// by parsing their response records with the same content handlers as a crawl.
+
+var WARCDir = ""
+var WARCMaxSize = 1024 * 1024 * 1024
+
+const warcVersion = "WARC/1.1"
+
+// The most of a body left unread by the crawl that is read to archive it
+const warcUnreadSize = 1024 * 1024
+
+// One header line of a WARC record
+type warcField struct {
+	name, value string
+}
+
+func warcRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func warcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// The sha1 digest of data in the form of the WARC digest fields
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// Writes the records of one crawl to a numbered series of .warc.gz files
+type warcWriter struct {
+	dir, prefix string
+	file        *os.File
+	files       int
+	size        int64
+	mux         sync.Mutex
+}
+
+func newWARCWriter(dir string, started time.Time) (*warcWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ww := &warcWriter{dir: dir, prefix: "searcher-" + started.UTC().Format("20060102150405")}
+	return ww, ww.nextFile()
+}
+
+// Close the current file, if any, and start the next with a warcinfo record
+func (ww *warcWriter) nextFile() error {
+	if ww.file != nil {
+		err := ww.file.Close()
+		ww.file = nil
+		if err != nil {
+			return err
+		}
+	}
+	name := fmt.Sprintf("%v-%05d.warc.gz", ww.prefix, ww.files)
+	f, err := os.OpenFile(filepath.Join(ww.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		ww.file = nil
+		return err
+	}
+	ww.file, ww.size = f, 0
+	ww.files++
+	info := fmt.Sprintf("software: searcher/%v\r\nformat: WARC File Format 1.1\r\nhttp-header-user-agent: %v\r\n", version, UserAgent)
+	return ww.write([]warcField{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", warcRecordID()},
+		{"WARC-Date", warcDate(time.Now())},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(info))
+}
+
+// Write one uncompressed record
+func writeWARCRecord(w io.Writer, fields []warcField, block []byte) error {
+	var record bytes.Buffer
+	fmt.Fprintf(&record, "%v\r\n", warcVersion)
+	for _, f := range fields {
+		fmt.Fprintf(&record, "%v: %v\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(&record, "Content-Length: %v\r\n\r\n", len(block))
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+	_, err := w.Write(record.Bytes())
+	return err
+}
+
+// Write one gzip-compressed record
+func (ww *warcWriter) write(fields []warcField, block []byte) error {
+	if ww.file == nil {
+		return fmt.Errorf("no WARC file open")
+	}
+	var record bytes.Buffer
+	gz := gzip.NewWriter(&record)
+	if err := writeWARCRecord(gz, fields, block); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	n, err := ww.file.Write(record.Bytes())
+	ww.size += int64(n)
+	return err
+}
+
+// Write a request and its response, rolling over to a new file first if the
+// current one is full
+func (ww *warcWriter) WriteExchange(request, response []warcField, requestBlock, responseBlock []byte) error {
+	ww.mux.Lock()
+	defer ww.mux.Unlock()
+	if WARCMaxSize > 0 && ww.size >= int64(WARCMaxSize) {
+		if err := ww.nextFile(); err != nil {
+			return err
+		}
+	}
+	if err := ww.write(request, requestBlock); err != nil {
+		return err
+	}
+	return ww.write(response, responseBlock)
+}
+
+func (ww *warcWriter) Close() error {
+	ww.mux.Lock()
+	defer ww.mux.Unlock()
+	if ww.file == nil {
+		return nil
+	}
+	err := ww.file.Close()
+	ww.file = nil
+	return err
+}
+
+// The writer for the crawl in progress, if it is being archived
+var crawlWARC struct {
+	w   *warcWriter
+	mux sync.Mutex
+}
+
+func setCrawlWARC(w *warcWriter) {
+	crawlWARC.mux.Lock()
+	crawlWARC.w = w
+	crawlWARC.mux.Unlock()
+}
+
+func currentWARC() *warcWriter {
+	crawlWARC.mux.Lock()
+	defer crawlWARC.mux.Unlock()
+	return crawlWARC.w
+}
+
+// Start archiving a crawl if WARCDir is set.  The returned func stops it.
+func startWARC() func() {
+	if WARCDir == "" {
+		return func() {}
+	}
+	w, err := newWARCWriter(WARCDir, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write WARC files to %v: %v\n", WARCDir, err)
+		return func() {}
+	}
+	setCrawlWARC(w)
+	return func() {
+		setCrawlWARC(nil)
+		if err := w.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write WARC files to %v: %v\n", WARCDir, err)
+		}
+	}
+}
+
+// Wraps the client's transport to archive every http(s) exchange while a crawl
+// is being archived
+type warcTransport struct {
+	next http.RoundTripper
+}
+
+func (t warcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := currentWARC()
+	if w == nil || (req.URL.Scheme != "http" && req.URL.Scheme != "https") {
+		return t.next.RoundTrip(req)
+	}
+	// as sent, with the headers the transport adds
+	requestBlock, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return t.next.RoundTrip(req)
+	}
+	date := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &warcBody{ReadCloser: resp.Body, w: w, resp: resp, date: date, requestBlock: requestBlock}
+	return resp, nil
+}
+
+// A response body that keeps what is read from it, and on Close reads the rest
+// up to MaxBodySize, or warcUnreadSize more, and archives the exchange.  What is
+// read on Close draws on the crawl's maxbytes like the rest of the body.
+type warcBody struct {
+	io.ReadCloser
+	w            *warcWriter
+	resp         *http.Response
+	date         time.Time
+	requestBlock []byte
+	body         bytes.Buffer
+	readErr      error
+	closed       bool
+}
+
+func (b *warcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.body.Write(p[:n])
+	if err != nil && err != io.EOF {
+		b.readErr = err
+	}
+	return n, err
+}
+
+func (b *warcBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	truncated := ""
+	if b.readErr == nil {
+		// one byte more than is kept shows whether there was more
+		limit := b.body.Len() + warcUnreadSize
+		if MaxBodySize > 0 && MaxBodySize < limit {
+			limit = MaxBodySize
+		}
+		rest := io.Reader(b.ReadCloser)
+		var budget *byteBudgetReader
+		if limits, ok := requestLimits(b.resp.Request); ok {
+			budget = &byteBudgetReader{r: rest, limits: limits}
+			rest = budget
+		}
+		if _, err := b.body.ReadFrom(io.LimitReader(rest, int64(limit-b.body.Len()+1))); err != nil {
+			b.readErr = err
+		}
+		if b.body.Len() > limit {
+			b.body.Truncate(limit)
+			truncated = "length"
+		} else if budget != nil && budget.truncated {
+			truncated = "length"
+		}
+	} else if MaxBodySize > 0 && b.body.Len() > MaxBodySize {
+		b.body.Truncate(MaxBodySize)
+	}
+	if b.readErr != nil {
+		truncated = "disconnect"
+	}
+	if err := b.archive(truncated); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to archive %v: %v\n", b.resp.Request.URL, err)
+	}
+	return b.ReadCloser.Close()
+}
+
+func (b *warcBody) archive(truncated string) error {
+	request, response, responseBlock := warcExchange(b.requestBlock, b.resp, b.body.Bytes(), b.date, truncated)
+	return b.w.WriteExchange(request, response, b.requestBlock, responseBlock)
+}
+
+// The records for a request, dumped as sent, and its response and body.  The
+// request record refers to the response.
+func warcExchange(requestBlock []byte, resp *http.Response, body []byte, sent time.Time, truncated string) ([]warcField, []warcField, []byte) {
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "%v %v\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&block)
+	block.WriteString("\r\n")
+	block.Write(body)
+
+	target := resp.Request.URL.String()
+	date := warcDate(sent)
+	responseID := warcRecordID()
+	response := []warcField{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"Content-Type", "application/http; msgtype=response"},
+		{"WARC-Payload-Digest", warcDigest(body)},
+		{"WARC-Block-Digest", warcDigest(block.Bytes())},
+	}
+	if truncated != "" {
+		response = append(response, warcField{"WARC-Truncated", truncated})
+	}
+	request := []warcField{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", warcRecordID()},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http; msgtype=request"},
+		{"WARC-Block-Digest", warcDigest(requestBlock)},
+	}
+	return request, response, block.Bytes()
+}
+
+// Reads the records of a WARC file in turn
+type warcReader struct {
+	r     *bufio.Reader
+	block *io.LimitedReader
+}
+
+// A WARC file, gzip-compressed per record or as a whole, or not at all
+func newWARCReader(r io.Reader) (*warcReader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+	return &warcReader{r: br}, nil
+}
+
+// The header of the next record, and a reader for its block which is valid
+// until Next is called again.  Returns io.EOF after the last record.
+func (wr *warcReader) Next() (textproto.MIMEHeader, io.Reader, error) {
+	if wr.block != nil {
+		if _, err := io.Copy(io.Discard, wr.block); err != nil {
+			return nil, nil, err
+		}
+	}
+	var line string
+	for line == "" {
+		l, err := wr.r.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return nil, nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		line = strings.TrimRight(l, "\r\n")
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, nil, fmt.Errorf("not a WARC record: %.40q", line)
+	}
+	header, err := textproto.NewReader(wr.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, nil, fmt.Errorf("bad WARC Content-Length %q", header.Get("Content-Length"))
+	}
+	wr.block = &io.LimitedReader{R: wr.r, N: length}
+	return header, wr.block, nil
+}
+
+// The response archived in a response or resource record, or nil for other
+// records and urls that couldn't have been crawled
+func warcResponse(header textproto.MIMEHeader, block io.Reader) (*http.Response, error) {
+	target, err := url.Parse(strings.Trim(header.Get("WARC-Target-URI"), "<>"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil, nil
+	}
+	req := &http.Request{Method: "GET", URL: target, Header: make(http.Header)}
+	switch header.Get("WARC-Type") {
+	case "response":
+		if !strings.HasPrefix(header.Get("Content-Type"), "application/http") {
+			return nil, nil
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(block), req)
+		if err == nil {
+			resp.Body = truncatedBody{resp.Body}
+		}
+		return resp, err
+	case "resource":
+		resp := &http.Response{Status: "200 OK", StatusCode: http.StatusOK, Proto: "HTTP/1.0", ProtoMajor: 1,
+			Header: make(http.Header), Body: io.NopCloser(block), Request: req}
+		if contentType := header.Get("Content-Type"); contentType != "" {
+			resp.Header.Set("Content-Type", contentType)
+		}
+		return resp, nil
+	}
+	return nil, nil
+}
+
+// Archived bodies cut short at MaxBodySize end before their Content-Length, which
+// is not an error when reading them back
+type truncatedBody struct {
+	io.ReadCloser
+}
+
+func (b truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Build the index from the response records of WARC files, without any network
+// access.  Records for pages already indexed are skipped, as are responses
+// other than 2xx, such as redirects.
+func ImportWARC(args string, visited *VisitedMap, index *Index, titles *URLtitles) {
+	var names []string
+	for _, arg := range strings.Fields(args) {
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			matches = []string{arg}
+		}
+		names = append(names, matches...)
+	}
+
+	var results crawlSummary
+	for _, name := range names {
+		progressf("Importing %v\n", name)
+		if err := importWARCFile(name, &results, visited, index, titles); err != nil {
+			fmt.Printf("Unable to read WARC file %v: %v\n", name, err)
+		}
+	}
+	progressf("\n")
+	lastCrawlErrors = results.errors
+	renderCrawlSummary(args, results)
+}
+
+func importWARCFile(name string, results *crawlSummary, visited *VisitedMap, index *Index, titles *URLtitles) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	wr, err := newWARCReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		header, block, err := wr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp, err := warcResponse(header, block)
+		target := header.Get("WARC-Target-URI")
+		if err != nil {
+			results.errors = append(results.errors, crawlError{target, err.Error(), 1})
+			continue
+		}
+		if resp == nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+			continue
+		}
+		requestURL := normalizeURL(resp.Request.URL.String())
+		crawl, doIndexing := visited.CheckAndVisit(visitedKey(requestURL), 0)
+		if !crawl {
+			continue
+		}
+		page := parseResponse(resp.Request.URL.String(), resp)
+		if header.Get("WARC-Truncated") != "" && page.Truncated == "" {
+			page.Truncated = "maxbodysize"
+			if !page.Handled {
+				// as when fetched, a record cut short is truncated, not unreadable
+				page.Err = nil
+			}
+		}
+		results.uniquePages++
+		if results.uniquePages%10 == 0 {
+			progressf(".")
+		}
+		if page.Err != nil {
+			results.errors = append(results.errors, crawlError{page.URL, page.Err.Error(), 1})
+		} else if !page.Parsed() || page.NoIndex {
+			results.notIndexed++
+		}
+		if page.Truncated != "" {
+			results.truncated++
+		}
+		unique, duplicate := recordPage(requestURL, 0, doIndexing, page, visited, index, titles)
+		results.uniqueTerms += unique
+		if duplicate {
+			results.duplicates++
+		}
+	}
+}
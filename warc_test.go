@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Never ends, like a stream the crawl stops reading
+type endlessBody struct{}
+
+func (endlessBody) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func (endlessBody) Close() error { return nil }
+
+// An abandoned endless body is archived up to warcUnreadSize, marked truncated,
+// even with no maxbodysize
+func TestWARCBodyCloseIsBounded(t *testing.T) {
+	maxBody := MaxBodySize
+	defer func() { MaxBodySize = maxBody }()
+	MaxBodySize = 0
+
+	dir := t.TempDir()
+	w, err := newWARCWriter(dir, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/stream", nil)
+	resp := &http.Response{Proto: "HTTP/1.1", Status: "200 OK", StatusCode: 200, Header: make(http.Header), Request: req}
+	body := &warcBody{ReadCloser: endlessBody{}, w: w, resp: resp, date: time.Now(), requestBlock: []byte("GET /stream HTTP/1.1\r\n\r\n")}
+	body.Read(make([]byte, 100))
+
+	closed := make(chan struct{})
+	go func() {
+		body.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close didn't return")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	wr, err := newWARCReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for {
+		header, block, err := wr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Get("WARC-Type") != "response" {
+			continue
+		}
+		found = true
+		data, _ := io.ReadAll(block)
+		if header.Get("WARC-Truncated") != "length" || len(data) > warcUnreadSize+1000 {
+			t.Errorf("archived %v bytes truncated %q, want at most %v and length", len(data), header.Get("WARC-Truncated"), warcUnreadSize)
+		}
+	}
+	if !found {
+		t.Errorf("no response record archived")
+	}
+}
+
+func TestWARCWriteErrors(t *testing.T) {
+	w, err := newWARCWriter(t.TempDir(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.file.Close()
+	if err := w.WriteExchange(nil, nil, []byte("request"), []byte("response")); err == nil {
+		t.Errorf("writing to a closed file succeeded")
+	}
+}
+
+// What Close reads for the archive draws on maxbytes
+func TestWARCBodyCloseKeepsToMaxBytes(t *testing.T) {
+	maxBody, maxBytes := MaxBodySize, MaxBytes
+	defer func() { MaxBodySize, MaxBytes = maxBody, maxBytes }()
+	MaxBodySize, MaxBytes = 0, 5000
+
+	w, err := newWARCWriter(t.TempDir(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	limits := newCrawlLimits()
+	ctx, cancel := limits.Context()
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/stream", nil)
+	resp := &http.Response{Proto: "HTTP/1.1", Status: "200 OK", StatusCode: 200, Header: make(http.Header), Request: req}
+	body := &warcBody{ReadCloser: endlessBody{}, w: w, resp: resp, date: time.Now(), requestBlock: []byte("GET /stream HTTP/1.1\r\n\r\n")}
+	body.Close()
+	if counts := limits.Counts(); counts.Bytes != 5000 || body.body.Len() != 5000 || limits.Stopped() != "maxbytes" {
+		t.Errorf("archived %v bytes with %v drawn and stopped by %q, want 5000, 5000 and maxbytes", body.body.Len(), counts.Bytes, limits.Stopped())
+	}
+}